@@ -1,14 +1,27 @@
 package elastic
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
 
-var verbs = [4]string{"POST", "PUT", "DELETE", "HEAD"}
+var verbs = [7]string{"POST", "PUT", "DELETE", "HEAD", "GET", "PATCH", "OPTIONS"}
 
 // Action contains the actual changes to apply to elastic search
 type Action struct {
 	HTTPVerb string
 	URL      string
 	JSON     string
+	Assert   *Assertion //Optional checks against the response
+	Retrys   int        //Number of attempts from the header or the retry option in the URL
+
+	bodyFile   string        //Optional, the body is streamed from this file instead of JSON
+	bodyOffset int64         //Where the body starts in bodyFile
+	bodyEnd    int64         //Where the body ends in bodyFile, 0 for the end of the file
+	bodyBefore string        //Optional, sent before the body (a seed update wraps the document)
+	bodyAfter  string        //Optional, sent after the body
+	tokens     tokenReplacer //Optional, replaces the tokens of the body streamed from bodyFile
 }
 
 // Validate will ensure the Action is properly formated and syntactically correct
@@ -27,16 +40,66 @@ func (a Action) Validate() error {
 	return nil
 }
 
+// IsNDJSON determines if the body is newline delimited JSON (_bulk and _msearch)
+func (a Action) IsNDJSON() bool {
+	return isNDJSONURL(a.URL)
+}
+
+// ContentType is the content type of the body
+func (a Action) ContentType() string {
+	if a.IsNDJSON() {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
 func (a Action) verbValid() error {
+	if isVerb(a.HTTPVerb) {
+		return nil
+	}
+	return ErrBadHTTPVerb
+}
+
+// isVerb determines if verb can be sent as an HTTP method. Any upper case
+// token is allowed so proxies and plugins with custom methods still work
+func isVerb(verb string) bool {
+	if verb == "" {
+		return false
+	}
+	for _, c := range verb {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// isStandardVerb determines if verb is one of the common HTTP methods
+func isStandardVerb(verb string) bool {
 	for _, v := range verbs {
-		if v == a.HTTPVerb {
-			return nil
+		if v == verb {
+			return true
 		}
 	}
-	return ErrBadHTTPVerb
+	return false
 }
 
+// jsonValid ensures the body is a JSON object or a JSON object per line
+// for _bulk and _msearch. An empty body is allowed for requests like GET,
+// DELETE or _refresh
 func (a Action) jsonValid() error {
+	if a.bodyFile != "" {
+		return a.streamValid()
+	}
+	if strings.TrimSpace(a.JSON) == "" {
+		return nil
+	}
+	if a.IsNDJSON() {
+		if _, err := ndjsonValid(a.JSON); err != nil {
+			return ErrBadJSON
+		}
+		return nil
+	}
 	var js map[string]interface{}
 	err := json.Unmarshal([]byte(a.JSON), &js)
 	if err == nil {
@@ -45,3 +108,47 @@ func (a Action) jsonValid() error {
 	return ErrBadJSON
 
 }
+
+// streamValid is jsonValid for a body streamed from its file, which is
+// checked as it is read rather than held in memory
+func (a Action) streamValid() error {
+	body, err := a.body()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if a.IsNDJSON() {
+		scanner := newLineScanner(body)
+		for scanner.Scan() {
+			if _, err := ndjsonValid(scanner.Text()); err != nil {
+				return ErrBadJSON
+			}
+		}
+		return scanner.Err()
+	}
+
+	dec := json.NewDecoder(body)
+	t, err := dec.Token()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil || t != json.Delim('{') {
+		return ErrBadJSON
+	}
+	for depth := 1; depth > 0; {
+		t, err := dec.Token()
+		if err != nil {
+			return ErrBadJSON
+		}
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return ErrBadJSON
+	}
+	return nil
+}
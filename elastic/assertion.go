@@ -0,0 +1,169 @@
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// assertKeyword is the line that separates the request body from
+// the optional assertion block in a schema file
+const assertKeyword = "ASSERT"
+
+// Assertion is checked against the response of an Action. A schema change
+// with a failing assertion is not applied and stops the deployment
+//
+//	{
+//	    "status": 200,
+//	    "equals": { "$.count": 42 },
+//	    "exists": [ "$.my_index.aliases.my_alias" ]
+//	}
+type Assertion struct {
+	Status int                    `json:"status"` //Expected HTTP status, defaults to 200
+	Equals map[string]interface{} `json:"equals"` //JSONPath to the expected value
+	Exists []string               `json:"exists"` //JSONPaths that must be present
+}
+
+// parseAssertion will decode an assertion block and verify all JSONPaths are well formed
+func parseAssertion(text string) (*Assertion, error) {
+	a := new(Assertion)
+	if err := json.Unmarshal([]byte(text), a); err != nil {
+		return nil, err
+	}
+	if a.Status == 0 {
+		a.Status = 200
+	}
+	for p := range a.Equals {
+		if _, err := parseJSONPath(p); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range a.Exists {
+		if _, err := parseJSONPath(p); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Check will verify the response status and body match the assertion
+func (a *Assertion) Check(status int, body []byte) error {
+	if status != a.Status {
+		return ErrAssertion{Message: fmt.Sprintf("expected status %d but got %d: %s", a.Status, status, body)}
+	}
+	if len(a.Equals) == 0 && len(a.Exists) == 0 {
+		return nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return ErrAssertion{Message: fmt.Sprintf("response is not valid JSON: %v", err)}
+	}
+	for _, p := range a.Exists {
+		if _, ok, err := jsonPath(doc, p); err != nil || !ok {
+			return ErrAssertion{Message: fmt.Sprintf("expected %s to exist", p)}
+		}
+	}
+	for p, expected := range a.Equals {
+		actual, ok, err := jsonPath(doc, p)
+		if err != nil || !ok {
+			return ErrAssertion{Message: fmt.Sprintf("expected %s to equal %v but it does not exist", p, expected)}
+		}
+		if !jsonEqual(expected, actual) {
+			return ErrAssertion{Message: fmt.Sprintf("expected %s to equal %v but got %v", p, expected, actual)}
+		}
+	}
+	return nil
+}
+
+// jsonEqual compares decoded JSON values. Scalars are compared by their
+// text so "1" matches 1, as elastic search returns most settings as strings
+func jsonEqual(expected, actual interface{}) bool {
+	if reflect.DeepEqual(expected, actual) {
+		return true
+	}
+	switch expected.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	switch actual.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return fmt.Sprint(expected) == fmt.Sprint(actual)
+}
+
+// jsonPath evaluates a simple JSONPath ($.a.b[0]['c.d']) against a decoded
+// JSON document. Returns the value found and if it exists
+func jsonPath(doc interface{}, path string) (interface{}, bool, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	current := doc
+	for _, seg := range segments {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			key, ok := seg.(string)
+			if !ok {
+				return nil, false, nil
+			}
+			if current, ok = v[key]; !ok {
+				return nil, false, nil
+			}
+		case []interface{}:
+			i, ok := seg.(int)
+			if !ok || i < 0 || i >= len(v) {
+				return nil, false, nil
+			}
+			current = v[i]
+		default:
+			return nil, false, nil
+		}
+	}
+	return current, true, nil
+}
+
+// parseJSONPath splits a JSONPath into its segments. Object keys are
+// returned as strings and array indexes as ints
+func parseJSONPath(path string) ([]interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSONPath %q must start with $", path)
+	}
+	var segments []interface{}
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("JSONPath %q has an empty key", path)
+			}
+			segments = append(segments, key)
+			rest = rest[end+1:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("JSONPath %q is missing ]", path)
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				segments = append(segments, inner[1:len(inner)-1])
+			} else if i, err := strconv.Atoi(inner); err == nil {
+				segments = append(segments, i)
+			} else {
+				return nil, fmt.Errorf("JSONPath %q has an invalid index [%s]", path, inner)
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("JSONPath %q is invalid near %q", path, rest)
+		}
+	}
+	return segments, nil
+}
@@ -0,0 +1,68 @@
+package elastic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const settingsResponse = `{
+	"my_index": {
+		"aliases": { "my_alias": {} },
+		"settings": { "index.number_of_shards": "3" },
+		"nodes": [ { "name": "a" }, { "name": "b" } ]
+	}
+}`
+
+func TestJSONPath(t *testing.T) {
+	segments, err := parseJSONPath("$.my_index.settings['index.number_of_shards'][1]")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"my_index", "settings", "index.number_of_shards", 1}, segments)
+
+	_, err = parseJSONPath("my_index.settings")
+	assert.Error(t, err)
+	_, err = parseJSONPath("$.nodes[x]")
+	assert.Error(t, err)
+}
+
+func TestAssertionCheck(t *testing.T) {
+	a, err := parseAssertion(`{
+		"equals": {
+			"$.my_index.settings['index.number_of_shards']": 3,
+			"$.my_index.nodes[1].name": "b"
+		},
+		"exists": [ "$.my_index.aliases.my_alias" ]
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, 200, a.Status)
+	assert.NoError(t, a.Check(200, []byte(settingsResponse)))
+	assert.IsType(t, ErrAssertion{}, a.Check(404, []byte(settingsResponse)))
+}
+
+func TestAssertionCheckFailures(t *testing.T) {
+	a, err := parseAssertion(`{ "exists": [ "$.my_index.aliases.other_alias" ] }`)
+	require.NoError(t, err)
+	assert.IsType(t, ErrAssertion{}, a.Check(200, []byte(settingsResponse)))
+
+	a, err = parseAssertion(`{ "equals": { "$.my_index.nodes[0].name": "b" } }`)
+	require.NoError(t, err)
+	assert.IsType(t, ErrAssertion{}, a.Check(200, []byte(settingsResponse)))
+}
+
+func TestAssertionExpectedStatus(t *testing.T) {
+	a, err := parseAssertion(`{ "status": 404 }`)
+	require.NoError(t, err)
+	assert.NoError(t, a.Check(404, []byte(`{}`)))
+	assert.Error(t, a.Check(200, []byte(`{}`)))
+}
+
+func TestParseFileWithAssertion(t *testing.T) {
+	sc, err := NewSchemaChange("../tests", "../tests/assert_doc_count.js", -1, -1)
+	require.NoError(t, err)
+	assert.Equal(t, "GET", sc.Actions[0].HTTPVerb)
+	assert.Equal(t, "", sc.Actions[0].JSON)
+	require.NotNil(t, sc.Actions[0].Assert)
+	assert.Equal(t, float64(42), sc.Actions[0].Assert.Equals["$.count"])
+	assert.NoError(t, sc.Actions[0].Validate())
+}
@@ -0,0 +1,129 @@
+package elastic
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// lineScanner reads a file line by line like bufio.Scanner but without
+// its 64KB limit, so minified mappings and stored scripts of any size can
+// be read. Line endings (\n or \r\n) are removed
+type lineScanner struct {
+	r    *bufio.Reader
+	text string
+	err  error
+	read int64 //number of bytes read so far
+}
+
+func newLineScanner(r io.Reader) *lineScanner {
+	return &lineScanner{r: bufio.NewReader(r)}
+}
+
+// Scan advances to the next line, returning false at the end of the input
+// or on an error
+func (s *lineScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	line, err := s.r.ReadString('\n')
+	s.read += int64(len(line))
+	if err != nil {
+		s.err = err
+		if line == "" {
+			return false
+		}
+	}
+	s.text = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	return true
+}
+
+// Text is the current line
+func (s *lineScanner) Text() string {
+	return s.text
+}
+
+// Err is the first error other than io.EOF
+func (s *lineScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// tokenReplacer replaces the {{tokens}} of a body while it is streamed
+type tokenReplacer interface {
+	reader(r io.Reader) io.Reader
+}
+
+// body returns the request body of the Action, or nil when there is none.
+// A body read from a file is streamed with its comments removed, its tokens
+// replaced and for _bulk and _msearch without blank lines
+func (a Action) body() (io.ReadCloser, error) {
+	if a.bodyFile != "" {
+		file, err := os.Open(a.bodyFile)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Seek(a.bodyOffset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		var r io.Reader = file
+		if a.bodyEnd > 0 {
+			r = io.LimitReader(file, a.bodyEnd-a.bodyOffset)
+		}
+		r = newCommentReader(r)
+		if a.tokens != nil {
+			r = a.tokens.reader(r)
+		}
+		if a.IsNDJSON() {
+			r = newNDJSONReader(r)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(strings.NewReader(a.bodyBefore), r, strings.NewReader(a.bodyAfter)), file}, nil
+	}
+	if a.JSON == "" {
+		return nil, nil
+	}
+	return ioutil.NopCloser(strings.NewReader(a.bodyBefore + a.JSON + a.bodyAfter)), nil
+}
+
+// isBlank determines if the body has nothing but whitespace, reading no
+// further than the first other character
+func isBlank(body io.Reader) (bool, error) {
+	br := bufio.NewReader(body)
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return false, nil
+		}
+	}
+}
+
+// gzipBody compresses the body while it is sent. Nothing is buffered, the
+// compressed bytes are written to the request as they are read
+func gzipBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, body)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
@@ -0,0 +1,107 @@
+package elastic
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineScannerLongLines(t *testing.T) {
+	long := strings.Repeat("a", 200*1024)
+	s := newLineScanner(strings.NewReader("PUT\r\n" + long + "\nlast"))
+	var lines []string
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	require.NoError(t, s.Err())
+	assert.Equal(t, []string{"PUT", long, "last"}, lines)
+	assert.Equal(t, int64(len(long)+10), s.read)
+}
+
+// bodyText reads the body an Action sends
+func bodyText(t *testing.T, a Action) string {
+	body, err := a.body()
+	require.NoError(t, err)
+	if body == nil {
+		return ""
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestLargeScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mapping := `{"mappings":{"properties":{"f":{"type":"keyword","meta":{"doc":"` + strings.Repeat("x", 100*1024) + `"}}}}}`
+	file := writeScript(t, dir, "test/01.js", "PUT\nbig_v1\n"+mapping)
+	sc, err := NewSchemaChange(dir, file, 1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, mapping, bodyText(t, sc.Actions[0]))
+	assert.NoError(t, sc.Actions[0].Validate())
+}
+
+func TestScriptBodiesStreamed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := writeScript(t, dir, "01.js", "PUT cars_v1\r\n{ \"shards\": {{shards}} }\r\n\r\n"+
+		"POST _bulk\r\n// seed\r\n{\"index\":{}}\r\n\r\n{\"n\":1}\r\n"+
+		"DELETE cars_v0\r\n/* gone */\r\n")
+	sc, err := NewSchemaChange(dir, file, 2, 0)
+	require.NoError(t, err)
+	require.Len(t, sc.Actions, 3)
+	for _, a := range sc.Actions {
+		assert.Empty(t, a.JSON) //nothing is held in memory
+		assert.NoError(t, a.Validate())
+	}
+	assert.Equal(t, "{ \"shards\": 2 }\r\n\r\n", bodyText(t, sc.Actions[0]))
+	assert.Equal(t, "{\"index\":{}}\n{\"n\":1}\n", bodyText(t, sc.Actions[1]))
+	assert.Equal(t, "", bodyText(t, sc.Actions[2]))
+
+	sc.Actions[0].bodyEnd = sc.Actions[0].bodyOffset + 5 //{ "sh
+	assert.Equal(t, ErrBadJSON, sc.Actions[0].Validate())
+}
+
+func TestSeedStreamsCompressedBody(t *testing.T) {
+	var got, encoding string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if seedRecords(w, r, nil) {
+			return
+		}
+		encoding = r.Header.Get("Content-Encoding")
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		body, _ := ioutil.ReadAll(zr)
+		got = string(body)
+		w.WriteHeader(201)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	doc := `{ "name": "` + strings.Repeat("y", 100*1024) + `" }`
+	file := writeScript(t, dir, "cars/1.js", "cars/_doc/1\n// seeded by the test\n"+doc)
+
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	seeder.Compress = true
+	results, err := seeder.Seed()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "Success: "+file, results[0])
+	assert.Equal(t, "gzip", encoding)
+	assert.Equal(t, strings.TrimSpace(got), doc)
+}
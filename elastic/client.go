@@ -0,0 +1,40 @@
+package elastic
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// newHTTPClient builds the client used to talk to elastic search by the
+// schema changer, seeder and exporter. Connecting times out but requests
+// do not, as a reindex or a large _bulk request can take minutes
+func newHTTPClient(allowInsecure bool) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 16, //seed workers reuse their connections
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: allowInsecure},
+		},
+	}
+}
+
+// normalizeServerURL ends the elastic search url with a /
+func normalizeServerURL(serverURL string) string {
+	if !strings.HasSuffix(serverURL, "/") {
+		serverURL += "/"
+	}
+	return serverURL
+}
+
+// joinURL adds the path of a request to the elastic search url
+func joinURL(serverURL, path string) string {
+	return strings.TrimSuffix(serverURL, "/") + "/" + strings.TrimPrefix(path, "/")
+}
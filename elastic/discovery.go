@@ -0,0 +1,134 @@
+package elastic
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile lists patterns of files and folders to skip, one per line.
+// It is read from the root of the folder being walked
+const ignoreFile = ".esdeployignore"
+
+// FileFilter controls which files are picked up from a folder by the
+// Runner and Seeder. Patterns are globs (*, ?, [a-z]) matched against the
+// path relative to the folder using / as the separator. A pattern without
+// a / matches the name of a file or folder at any level, ** matches any
+// number of folders and a leading / anchors it to the root folder
+//
+//	Include: []string{"cars/**"}
+//	Exclude: []string{"node_modules", "*.draft.js", "/scratch"}
+type FileFilter struct {
+	Include  []string //Files must match one of these when set
+	Exclude  []string //Files and folders to skip, added to those in .esdeployignore
+	Hidden   bool     //Walk hidden files and folders (starting with a .), skipped by default
+	MaxDepth int      //Folder levels to walk, 1 is only the root folder. 0 for no limit
+}
+
+// walk returns the files under dir accepted by the filter and by accept
+func (f FileFilter) walk(dir string, accept func(path string) bool) ([]string, error) {
+	exclude, err := readIgnoreFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	exclude = append(exclude, f.Exclude...)
+
+	fileList := []string{}
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		skip := (!f.Hidden && strings.HasPrefix(info.Name(), ".")) ||
+			(f.MaxDepth > 0 && strings.Count(rel, "/") >= f.MaxDepth) ||
+			matchAny(exclude, rel, info.IsDir())
+		if info.IsDir() {
+			if skip {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if skip || (len(f.Include) > 0 && !matchAny(f.Include, rel, false)) || !accept(p) {
+			return nil
+		}
+		fileList = append(fileList, p)
+		return nil
+	})
+	return fileList, err
+}
+
+// readIgnoreFile reads the patterns in the .esdeployignore file of dir.
+// Blank lines and lines starting with # are ignored
+func readIgnoreFile(dir string) ([]string, error) {
+	file, err := os.Open(filepath.Join(dir, ignoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// matchAny determines if the relative path matches one of the patterns.
+// Patterns ending in / only match folders
+func matchAny(patterns []string, rel string, isDir bool) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "/") {
+			if !isDir {
+				continue
+			}
+			p = strings.TrimSuffix(p, "/")
+		}
+		if matchGlob(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a pattern against a relative path, see FileFilter
+func matchGlob(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
@@ -0,0 +1,86 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchGlob(t *testing.T) {
+	assert.True(t, matchGlob("node_modules", "a/node_modules"))
+	assert.True(t, matchGlob("*.draft.js", "cars/01.draft.js"))
+	assert.True(t, matchGlob("/scratch", "scratch"))
+	assert.False(t, matchGlob("/scratch", "cars/scratch"))
+	assert.True(t, matchGlob("cars/**", "cars/v1/01.js"))
+	assert.True(t, matchGlob("**/01.js", "01.js"))
+	assert.True(t, matchGlob("cars/**/*.js", "cars/a/b/01.js"))
+	assert.False(t, matchGlob("cars/*.js", "cars/a/01.js"))
+}
+
+func TestFileFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, f := range []string{
+		"01.js", "cars/01.js", "cars/v2/01.js", "cars/01.draft.js", "trucks/01.js",
+		".git/hooks/01.js", "node_modules/lib/index.js", "scratch/01.js", "cars/.hidden.js",
+	} {
+		writeScript(t, dir, f, "GET\n_cat/indices")
+	}
+	writeScript(t, dir, ignoreFile, "# generated scripts\nscratch/\n")
+
+	files := func(f FileFilter) []string {
+		found, err := f.walk(dir, isScriptFile)
+		require.NoError(t, err)
+		var rel []string
+		for _, p := range found {
+			r, _ := filepath.Rel(dir, p)
+			rel = append(rel, filepath.ToSlash(r))
+		}
+		sort.Strings(rel)
+		return rel
+	}
+
+	assert.Equal(t, []string{"01.js", "cars/01.draft.js", "cars/01.js", "cars/v2/01.js", "node_modules/lib/index.js", "trucks/01.js"},
+		files(FileFilter{}))
+	assert.Equal(t, []string{"01.js", "cars/01.js", "trucks/01.js"},
+		files(FileFilter{Exclude: []string{"node_modules", "*.draft.js"}, MaxDepth: 2}))
+	assert.Equal(t, []string{"cars/01.js", "cars/v2/01.js"},
+		files(FileFilter{Include: []string{"cars/**"}, Exclude: []string{"*.draft.js"}}))
+	assert.Contains(t, files(FileFilter{Hidden: true}), ".git/hooks/01.js")
+}
+
+func TestSeederSkipsPoison(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeScript(t, dir, "cars/1.js", "cars/_doc/1\n{}")
+	writeScript(t, dir, "poison/20200101000000/cars/2.js", "cars/_doc/2\n{}")
+	run := &seedRun{seeder: NewSeeder(dir, "", Creds{}, false)}
+	files, err := run.getFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "cars", "1.js")}, files)
+}
+
+func TestSeederSkipsReportAndUnmappedJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeScript(t, dir, "cars/1.js", "cars/_doc/1\n{}")
+	writeScript(t, dir, "cars/bikes.json", "[]")
+	writeScript(t, dir, "package.json", "{}")
+	report := writeScript(t, dir, "report.ndjson", "{}")
+	run := &seedRun{seeder: NewSeeder(dir, "", Creds{}, false), mappings: []seedMapping{{pattern: "cars/*.json", index: "bikes"}}}
+	run.seeder.ReportFile = report
+	files, err := run.getFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "cars", "1.js"), filepath.Join(dir, "cars", "bikes.json")}, files)
+}
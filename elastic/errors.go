@@ -1,6 +1,9 @@
 package elastic
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 //ErrBadHTTPVerb is when...
 var ErrBadHTTPVerb = errors.New("Unknown HTTP Verb")
@@ -9,6 +12,9 @@ var ErrBadJSON = errors.New("Invalid JSON document")
 
 var ErrEmptyURL = errors.New("URL is empty")
 
+// ErrBadYAML is when a YAML schema file can not be parsed
+var ErrBadYAML = errors.New("Invalid YAML document")
+
 type ErrSchemaChange struct {
 	Message string
 }
@@ -16,3 +22,66 @@ type ErrSchemaChange struct {
 func (e ErrSchemaChange) Error() string {
 	return e.Message
 }
+
+// ErrAssertion is returned when the response of an Action does not
+// match the assertion block of its schema file
+type ErrAssertion struct {
+	Message string
+}
+
+func (e ErrAssertion) Error() string {
+	return "assertion failed: " + e.Message
+}
+
+// ErrPrecondition is returned when the precondition of a schema change
+// is not met and it is declared to fail the deployment
+type ErrPrecondition struct {
+	ID     string
+	Reason string
+}
+
+func (e ErrPrecondition) Error() string {
+	return fmt.Sprintf("precondition for %s not met: %s", e.ID, e.Reason)
+}
+
+// ErrDependency is returned when a schema change depends on
+// another schema change that has not been applied
+type ErrDependency struct {
+	ID        string
+	DependsOn string
+}
+
+func (e ErrDependency) Error() string {
+	return fmt.Sprintf("%s depends on %s which has not been applied", e.ID, e.DependsOn)
+}
+
+// ErrLegacyID is returned when a schema change was applied under the ID
+// used before IDs were relative paths and the records have not been migrated
+type ErrLegacyID struct {
+	ID       string
+	LegacyID string
+}
+
+func (e ErrLegacyID) Error() string {
+	return fmt.Sprintf("%s was applied as %s, run esdeploy migrate to update the IDs", e.ID, e.LegacyID)
+}
+
+// ErrScriptFile is returned when a schema or seed file can not be read
+// or parsed. Line is 0 when the problem is not tied to a single line
+type ErrScriptFile struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e ErrScriptFile) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e ErrScriptFile) Unwrap() error {
+	return e.Err
+}
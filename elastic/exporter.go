@@ -0,0 +1,301 @@
+package elastic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+)
+
+// Export formats
+const (
+	ExportSeedFiles = "js"     //A seed file per document, the url on line 1 and the document
+	ExportNDJSON    = "ndjson" //A data file for the index with a document per line
+)
+
+// Exporter writes the documents of an index to seed files, so data from an
+// existing environment can be seeded elsewhere
+type Exporter struct {
+	Creds      Creds
+	HTTPClient *http.Client
+	ServerURL  string
+	Index      string
+	Directory  string
+	Format     string //ExportSeedFiles or ExportNDJSON
+	Query      string //Optional query clause (JSON) selecting the documents
+	PageSize   int    //Documents read in each request
+	KeepAlive  string //How long the scroll or point in time is kept between requests
+	PIT        bool   //Page with a point in time and search_after instead of a scroll, elastic search 7.12+, which sorts by _shard_doc
+	IDField    string //Optional field holding the ID of each document, otherwise NDJSON files keep the ID as _id
+}
+
+// NewExporter will initialize a new Exporter writing seed files
+func NewExporter(serverURL, index, directory string, creds Creds, allowInsecure bool) *Exporter {
+	return &Exporter{
+		HTTPClient: newHTTPClient(allowInsecure),
+		Creds:      creds,
+		ServerURL:  normalizeServerURL(serverURL),
+		Index:      index,
+		Directory:  directory,
+		Format:     ExportSeedFiles,
+		PageSize:   1000,
+		KeepAlive:  "1m",
+	}
+}
+
+// exportHit is a document in a search response
+type exportHit struct {
+	Index  string          `json:"_index"`
+	Type   string          `json:"_type"`
+	ID     string          `json:"_id"`
+	Source json.RawMessage `json:"_source"`
+	Sort   json.RawMessage `json:"sort"`
+}
+
+// exportPage is a search response
+type exportPage struct {
+	ScrollID string `json:"_scroll_id"`
+	PitID    string `json:"pit_id"`
+	Hits     struct {
+		Hits []exportHit `json:"hits"`
+	} `json:"hits"`
+}
+
+// Export reads every document of the index matching the query and writes
+// them to the directory. Existing files are overwritten
+func (e *Exporter) Export() ([]string, error) {
+	var results []string
+	if e.Query != "" && !json.Valid([]byte(e.Query)) {
+		return results, fmt.Errorf("%w: the query is not valid JSON", ErrBadJSON)
+	}
+	if err := os.MkdirAll(e.Directory, 0777); err != nil {
+		return results, err
+	}
+
+	var write func(exportHit) error
+	var ndjson *ndjsonExport
+	switch e.Format {
+	case ExportSeedFiles:
+		write = e.writeSeedFile
+	case ExportNDJSON:
+		ndjson = &ndjsonExport{exporter: e, files: make(map[string]*ndjsonFile)}
+		defer ndjson.close()
+		write = ndjson.write
+	default:
+		return results, fmt.Errorf("unknown export format %q", e.Format)
+	}
+
+	count := 0
+	err := e.pages(func(hits []exportHit) error {
+		for _, hit := range hits {
+			if err := write(hit); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err == nil && ndjson != nil {
+		err = ndjson.close()
+	}
+	results = append(results, fmt.Sprintf("Exported %d documents from %s to %s", count, e.Index, e.Directory))
+	return results, err
+}
+
+// writeSeedFile writes a document to index/id.js in the format read by the
+// Seeder. The index is the one of the document, as Index can be an alias,
+// a wildcard or a list of indices
+func (e *Exporter) writeSeedFile(hit exportHit) error {
+	docType := hit.Type
+	if docType == "" {
+		docType = "_doc"
+	}
+	var doc bytes.Buffer
+	if err := json.Indent(&doc, hit.Source, "", "  "); err != nil {
+		return err
+	}
+	dir := filepath.Join(e.Directory, exportFileName(hit.Index))
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("%s/%s/%s\n%s", hit.Index, docType, neturl.PathEscape(hit.ID), doc.String())
+	return ioutil.WriteFile(filepath.Join(dir, exportFileName(hit.ID)+".js"), []byte(content), 0666)
+}
+
+// ndjsonExport writes the documents of each index to its own index.ndjson
+// data file, created with its header when the first document is read. The
+// ID of a document is not part of its source, so without an IDField it is
+// added to each document as _id, which the Seeder takes out again
+type ndjsonExport struct {
+	exporter *Exporter
+	files    map[string]*ndjsonFile
+}
+
+type ndjsonFile struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func (n *ndjsonExport) write(hit exportHit) error {
+	f, ok := n.files[hit.Index]
+	if !ok {
+		file, err := os.Create(filepath.Join(n.exporter.Directory, exportFileName(hit.Index)+".ndjson"))
+		if err != nil {
+			return err
+		}
+		f = &ndjsonFile{file: file, writer: bufio.NewWriter(file)}
+		n.files[hit.Index] = f
+		idField := n.exporter.IDField
+		if idField == "" {
+			idField = "_id"
+		}
+		fmt.Fprintf(f.writer, "// index: %s\n// id: %s\n", hit.Index, idField)
+	}
+	var line bytes.Buffer
+	if err := json.Compact(&line, hit.Source); err != nil {
+		return err
+	}
+	doc := line.Bytes()
+	if n.exporter.IDField == "" {
+		doc = withID(doc, hit.ID)
+	}
+	if _, err := f.writer.Write(doc); err != nil {
+		return err
+	}
+	return f.writer.WriteByte('\n')
+}
+
+// close flushes and closes the files, returning the first error
+func (n *ndjsonExport) close() error {
+	var first error
+	for index, f := range n.files {
+		if err := f.writer.Flush(); err != nil && first == nil {
+			first = err
+		}
+		if err := f.file.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(n.files, index)
+	}
+	return first
+}
+
+// exportFileName escapes the characters of an ID or index that can not be
+// used in a file name
+func exportFileName(name string) string {
+	return neturl.QueryEscape(name)
+}
+
+// pages calls fn with each page of documents, with a scroll or a point in
+// time which is released at the end
+func (e *Exporter) pages(fn func([]exportHit) error) error {
+	query := json.RawMessage(`{"match_all":{}}`)
+	if e.Query != "" {
+		query = json.RawMessage(e.Query)
+	}
+
+	if !e.PIT {
+		var page exportPage
+		err := e.request("POST", fmt.Sprintf("%s/_search?scroll=%s", neturl.PathEscape(e.Index), e.KeepAlive), map[string]interface{}{
+			"size":  e.PageSize,
+			"query": query,
+			"sort":  []string{"_doc"},
+		}, &page)
+		defer func() {
+			if page.ScrollID != "" {
+				e.request("DELETE", "_search/scroll", map[string]interface{}{"scroll_id": page.ScrollID}, nil)
+			}
+		}()
+		for err == nil && len(page.Hits.Hits) > 0 {
+			if err = fn(page.Hits.Hits); err != nil {
+				break
+			}
+			err = e.request("POST", "_search/scroll", map[string]interface{}{"scroll": e.KeepAlive, "scroll_id": page.ScrollID}, &page)
+		}
+		return err
+	}
+
+	var pit struct {
+		ID string `json:"id"`
+	}
+	if err := e.request("POST", fmt.Sprintf("%s/_pit?keep_alive=%s", neturl.PathEscape(e.Index), e.KeepAlive), nil, &pit); err != nil {
+		return err
+	}
+	defer func() {
+		e.request("DELETE", "_pit", map[string]interface{}{"id": pit.ID}, nil)
+	}()
+	var after json.RawMessage
+	for {
+		search := map[string]interface{}{
+			"size":  e.PageSize,
+			"query": query,
+			"pit":   map[string]interface{}{"id": pit.ID, "keep_alive": e.KeepAlive},
+			"sort":  []string{"_shard_doc"},
+		}
+		if after != nil {
+			search["search_after"] = after
+		}
+		var page exportPage
+		if err := e.request("POST", "_search", search, &page); err != nil {
+			return err
+		}
+		hits := page.Hits.Hits
+		if len(hits) == 0 {
+			return nil
+		}
+		if err := fn(hits); err != nil {
+			return err
+		}
+		if page.PitID != "" {
+			pit.ID = page.PitID
+		}
+		after = hits[len(hits)-1].Sort
+	}
+}
+
+// request sends body as JSON to path and decodes the response into out
+func (e *Exporter) request(method, path string, body interface{}, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, joinURL(e.ServerURL, path), r)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	if e.Creds.AuthorizationNeeded() {
+		req.SetBasicAuth(e.Creds.Username, e.Creds.Password)
+	}
+
+	resp, err := e.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return errors.New(string(b))
+	}
+	if out != nil {
+		return json.Unmarshal(b, out)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package elastic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportServer is a fake elastic search returning three documents of the
+// cars alias, two in cars_v1 and one in cars_v2, in pages of two, with
+// either a scroll or a point in time
+func exportServer(t *testing.T, requests *[]string) *httptest.Server {
+	hits := []string{
+		`{"_index":"cars_v1","_type":"_doc","_id":"1","_source":{"make":"Ford"},"sort":[1]}`,
+		`{"_index":"cars_v1","_type":"_doc","_id":"a/2","_source":{"make":"Kia"},"sort":[2]}`,
+		`{"_index":"cars_v2","_type":"_doc","_id":"3","_source":{"make":"Volvo"},"sort":[3]}`,
+	}
+	page := func(w http.ResponseWriter, n int, id string) {
+		var from int
+		fmt.Sscan(id, &from)
+		to := from + n
+		if to > len(hits) {
+			to = len(hits)
+		}
+		if from > len(hits) {
+			from = len(hits)
+		}
+		fmt.Fprintf(w, `{"_scroll_id":"%d","pit_id":"%d","hits":{"hits":[%s]}}`, to, to, strings.Join(hits[from:to], ","))
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		*requests = append(*requests, r.Method+" "+r.URL.RequestURI()+" "+string(b))
+		var body struct {
+			Size     int    `json:"size"`
+			ScrollID string `json:"scroll_id"`
+			Pit      struct {
+				ID string `json:"id"`
+			} `json:"pit"`
+		}
+		json.Unmarshal(b, &body)
+		switch {
+		case r.Method == "DELETE":
+			w.Write([]byte(`{"succeeded":true}`))
+		case r.URL.Path == "/cars/_pit":
+			w.Write([]byte(`{"id":"0"}`))
+		case r.URL.Path == "/cars/_search":
+			page(w, body.Size, "0")
+		case r.URL.Path == "/_search/scroll":
+			page(w, 2, body.ScrollID)
+		case r.URL.Path == "/_search":
+			page(w, body.Size, body.Pit.ID)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func TestExportSeedFiles(t *testing.T) {
+	var requests []string
+	ts := exportServer(t, &requests)
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e := NewExporter(ts.URL+"/", "cars", dir, Creds{}, false)
+	e.PageSize = 2
+	e.Query = `{"term":{"make":"Ford"}}`
+	results, err := e.Export()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Exported 3 documents from cars to " + dir}, results)
+
+	assert.Contains(t, requests[0], `POST /cars/_search?scroll=1m {"query":{"term":{"make":"Ford"}},"size":2,"sort":["_doc"]}`)
+	assert.Equal(t, `DELETE /_search/scroll {"scroll_id":"3"}`, requests[len(requests)-1])
+
+	//written in the format the seeder reads
+	//in the index of each document rather than the alias
+	file := filepath.Join(dir, "cars_v1", "a%2F2.js")
+	b, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "cars_v1/_doc/a%2F2\n{\n  \"make\": \"Kia\"\n}", string(b))
+	a, err := NewSeeder(dir, "", Creds{}, false).getAction(file, "")
+	require.NoError(t, err)
+	meta, ok := bulkMeta(a)
+	require.True(t, ok)
+	assert.Equal(t, `{"index":{"_id":"a/2","_index":"cars_v1"}}`, string(meta))
+	files, _ := filepath.Glob(filepath.Join(dir, "cars_v1", "*.js"))
+	assert.Len(t, files, 2)
+	assert.FileExists(t, filepath.Join(dir, "cars_v2", "3.js"))
+}
+
+func TestExportNDJSONWithPointInTime(t *testing.T) {
+	var requests []string
+	ts := exportServer(t, &requests)
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e := NewExporter(ts.URL, "cars", dir, Creds{}, false)
+	e.Format = ExportNDJSON
+	e.PIT = true
+	e.PageSize = 2
+	e.IDField = "vin"
+	_, err = e.Export()
+	require.NoError(t, err)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "cars_v1.ndjson"))
+	require.NoError(t, err)
+	assert.Equal(t, "// index: cars_v1\n// id: vin\n{\"make\":\"Ford\"}\n{\"make\":\"Kia\"}\n", string(b))
+	b, err = ioutil.ReadFile(filepath.Join(dir, "cars_v2.ndjson"))
+	require.NoError(t, err)
+	assert.Equal(t, "// index: cars_v2\n// id: vin\n{\"make\":\"Volvo\"}\n", string(b))
+
+	//without an id field the ids are kept as _id
+	e.IDField = ""
+	_, err = e.Export()
+	require.NoError(t, err)
+	b, err = ioutil.ReadFile(filepath.Join(dir, "cars_v1.ndjson"))
+	require.NoError(t, err)
+	assert.Equal(t, "// index: cars_v1\n// id: _id\n{\"_id\":\"1\",\"make\":\"Ford\"}\n{\"_id\":\"a/2\",\"make\":\"Kia\"}\n", string(b))
+	assert.Contains(t, requests[2], `"search_after":[2]`)
+	assert.Equal(t, `DELETE /_pit {"id":"3"}`, requests[len(requests)-1])
+
+	e.Query = "{ not json"
+	_, err = e.Export()
+	assert.True(t, errors.Is(err, ErrBadJSON))
+}
@@ -0,0 +1,132 @@
+package elastic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headerPrefix starts every line of the optional header block before the
+// verb. Console files (.http) can also use #
+const headerPrefix = "//"
+
+// Header is the optional metadata at the top of a schema file. Each
+// line is a comment with a key and value, other comments are ignored
+//
+//	// description: Create the cars index
+//	// author: jane
+//	// ticket: CARS-42
+//	// retries: 3
+//	// timeout: 2m
+//	// expect: 200, 201
+//	// tags: cars, search
+//	// depends: common/01.001_create_template.js
+//	// run: onchange
+//	// previous: cars/01.001_create_index.js
+//	PUT
+//	cars_v1
+type Header struct {
+	Description    string
+	Author         string
+	Ticket         string
+	Retries        int
+	Timeout        time.Duration
+	ExpectedStatus []int    //Statuses treated as success, defaults to 200
+	Tags           []string //Free form labels stored with the version info
+	DependsOn      []string //IDs of schema changes that must be applied first
+	RunMode        string   //Overrides the run mode from the file name
+	PreviousIDs    []string //IDs the script was applied under before it was renamed or moved
+
+	lines   map[string]int //line number of each key
+	unknown []string       //keys that were not recognized
+}
+
+// parseHeader will read the key value pairs from the header comment lines.
+// firstLine is the line number of the first header line. When the
+// header is invalid the line number of the bad value is returned
+func parseHeader(lines []string, firstLine int) (*Header, int, error) {
+	h := &Header{lines: make(map[string]int)}
+	for i, l := range lines {
+		text := strings.TrimSpace(l)
+		if strings.HasPrefix(text, headerPrefix) {
+			text = strings.TrimSpace(strings.TrimPrefix(text, headerPrefix))
+		} else {
+			text = strings.TrimSpace(strings.TrimPrefix(text, "#"))
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || strings.ContainsAny(parts[0], " \t") {
+			continue //plain comment
+		}
+		key := strings.ToLower(parts[0])
+		value := strings.TrimSpace(parts[1])
+		line := firstLine + i
+		h.lines[key] = line
+
+		var err error
+		switch key {
+		case "description":
+			h.Description = value
+		case "author":
+			h.Author = value
+		case "ticket":
+			h.Ticket = value
+		case "retries":
+			h.Retries, err = strconv.Atoi(value)
+		case "timeout":
+			h.Timeout, err = time.ParseDuration(value)
+		case "expect":
+			for _, v := range splitList(value) {
+				status, err2 := strconv.Atoi(v)
+				if err2 != nil || status < 100 || status > 599 {
+					err = fmt.Errorf("invalid HTTP status %q", v)
+					break
+				}
+				h.ExpectedStatus = append(h.ExpectedStatus, status)
+			}
+		case "tags":
+			h.Tags = splitList(value)
+		case "depends":
+			h.DependsOn = splitList(value)
+		case "previous":
+			h.PreviousIDs = splitList(value)
+		case "run":
+			switch value {
+			case RunOnce, RunOnChange, RunAlways:
+				h.RunMode = value
+			default:
+				err = fmt.Errorf("run must be %s, %s or %s but was %q", RunOnce, RunOnChange, RunAlways, value)
+			}
+		default:
+			h.unknown = append(h.unknown, key)
+		}
+		if err != nil {
+			return nil, line, fmt.Errorf("header %s: %v", key, err)
+		}
+	}
+	return h, 0, nil
+}
+
+// expects determines if status is one of the expected statuses
+func (h *Header) expects(status int) bool {
+	if h == nil || len(h.ExpectedStatus) == 0 {
+		return status == 200
+	}
+	for _, s := range h.ExpectedStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
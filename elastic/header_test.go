@@ -0,0 +1,92 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const headerScript = `// Creates the cars index
+// description: Create the cars index
+// author: jane
+// ticket: CARS-42
+// retries: 3
+// timeout: 2m
+// expect: 200, 201
+// tags: cars, search
+// depends: common/01.js
+// run: onchange
+
+PUT
+cars_v1?retry=1
+{}`
+
+func TestParseHeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewSchemaChange(dir, writeScript(t, dir, "cars/01.js", headerScript), -1, -1)
+	require.NoError(t, err)
+	h := s.Header
+	assert.Equal(t, "Create the cars index", h.Description)
+	assert.Equal(t, "jane", h.Author)
+	assert.Equal(t, "CARS-42", h.Ticket)
+	assert.Equal(t, 2*time.Minute, h.Timeout)
+	assert.Equal(t, []int{200, 201}, h.ExpectedStatus)
+	assert.Equal(t, []string{"cars", "search"}, h.Tags)
+	assert.Equal(t, []string{"common/01.js"}, h.DependsOn)
+	assert.Empty(t, h.unknown)
+
+	assert.Equal(t, 3, s.Actions[0].Retrys)
+	assert.Equal(t, RunOnChange, s.RunMode)
+	assert.Equal(t, "PUT", s.Actions[0].HTTPVerb)
+	assert.Equal(t, "cars_v1", s.Actions[0].URL)
+	assert.True(t, h.expects(201))
+	assert.False(t, h.expects(202))
+}
+
+func TestParseHeaderBadValue(t *testing.T) {
+	_, line, err := parseHeader([]string{"// author: jane", "// retries: lots"}, 1)
+	assert.Error(t, err)
+	assert.Equal(t, 2, line)
+
+	_, _, err = parseHeader([]string{"// expect: 200, OK"}, 1)
+	assert.Error(t, err)
+}
+
+func TestDiagnoseHeaderLineNumbers(t *testing.T) {
+	diags := diagnoseContent(t, "// owner: jane\n// a plain comment\nPUT\n\n{ bad }")
+	require.Len(t, diags, 3)
+	assert.Equal(t, Diagnostic{SeverityWarning, RuleUnknownHeader, 1, `unknown header "owner" is ignored`}, diags[0])
+	assert.Equal(t, RuleEmptyURL, diags[1].Rule)
+	assert.Equal(t, 4, diags[1].Line)
+	assert.Equal(t, RuleBadJSON, diags[2].Rule)
+	assert.Equal(t, 5, diags[2].Line)
+}
+
+func TestDependencies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeScript(t, dir, "cars/01.js", "// depends: common/01.js\nPUT\ncars\n{}")
+
+	results, err := NewRunner(dir, nil).Validate()
+	require.NoError(t, err)
+	assert.False(t, results[0].IsValid)
+	assert.Equal(t, RuleUnknownDependency, results[0].Diagnostics[0].Rule)
+	assert.Equal(t, 1, results[0].Diagnostics[0].Line)
+
+	f := newFakeSchemaChanger()
+	_, err = NewRunner(dir, f).Deploy(1, 0)
+	assert.Equal(t, ErrDependency{ID: "cars/01.js", DependsOn: "common/01.js"}, err)
+
+	f.applied["common/01.js"] = true
+	_, err = NewRunner(dir, f).Deploy(1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, f.runs["cars/01.js"])
+}
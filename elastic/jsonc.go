@@ -0,0 +1,93 @@
+package elastic
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// stripComments removes // line comments and /* block */ comments from a
+// JSON body so scripts can be commented like any other .js file. Comments
+// are replaced by spaces and newlines are kept, so line numbers and offsets
+// still match the file. Text inside JSON strings is left untouched
+func stripComments(text string) string {
+	if !strings.Contains(text, "//") && !strings.Contains(text, "/*") {
+		return text
+	}
+	out, _ := ioutil.ReadAll(newCommentReader(strings.NewReader(text)))
+	return string(out)
+}
+
+// commentReader strips comments the same way as stripComments while the
+// body is streamed, so large seed documents are never held in memory
+type commentReader struct {
+	src      *bufio.Reader
+	inString bool
+	escaped  bool
+	inLine   bool //within a // comment
+	inBlock  bool //within a /* comment */
+	skip     bool //the second character of /* or */ is blanked
+}
+
+func newCommentReader(r io.Reader) *commentReader {
+	return &commentReader{src: bufio.NewReader(r)}
+}
+
+func (r *commentReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c, err := r.src.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		out := c
+		switch {
+		case r.skip:
+			r.skip = false
+			out = ' '
+		case r.inLine:
+			if c == '\n' {
+				r.inLine = false
+			} else {
+				out = ' '
+			}
+		case r.inBlock:
+			if c == '*' && r.next('/') {
+				r.inBlock, r.skip = false, true
+			}
+			if c != '\n' {
+				out = ' '
+			}
+		case r.inString:
+			if r.escaped {
+				r.escaped = false
+			} else if c == '\\' {
+				r.escaped = true
+			} else if c == '"' {
+				r.inString = false
+			}
+		case c == '"':
+			r.inString = true
+		case c == '/' && r.next('/'):
+			r.inLine = true
+			out = ' '
+		case c == '/' && r.next('*'):
+			//an unterminated comment runs to the end so validation reports the body
+			r.inBlock, r.skip = true, true
+			out = ' '
+		}
+		p[n] = out
+		n++
+		if n > 0 && r.src.Buffered() == 0 {
+			return n, nil //don't block waiting on more input
+		}
+	}
+	return n, nil
+}
+
+// next determines if the following character is c without consuming it
+func (r *commentReader) next(c byte) bool {
+	b, err := r.src.Peek(1)
+	return err == nil && b[0] == c
+}
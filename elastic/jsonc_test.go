@@ -0,0 +1,49 @@
+package elastic
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripComments(t *testing.T) {
+	text := "{\n  // line comment\n  \"url\": \"http://cars\", /* block\n comment */ \"a\": \"/* kept */\"\n}"
+	stripped := stripComments(text)
+	assert.Equal(t, len(text), len(stripped))
+	assert.Equal(t, strings.Count(text, "\n"), strings.Count(stripped, "\n"))
+
+	var js map[string]string
+	require.NoError(t, json.Unmarshal([]byte(stripped), &js))
+	assert.Equal(t, "http://cars", js["url"])
+	assert.Equal(t, "/* kept */", js["a"])
+}
+
+func TestStripCommentsEscapedQuote(t *testing.T) {
+	stripped := stripComments(`{ "a": "say \"//hi\"" } // done`)
+	assert.Equal(t, `{ "a": "say \"//hi\"" }        `, stripped)
+}
+
+func TestCommentedScript(t *testing.T) {
+	sc, err := NewSchemaChange("../tests", "../tests/commented.js", 1, 0)
+	require.NoError(t, err)
+	require.Len(t, sc.Actions, 1)
+	assert.NoError(t, sc.Actions[0].Validate())
+	assert.NotContains(t, bodyText(t, sc.Actions[0]), "stored scripts keep their layout")
+	assert.NotContains(t, bodyText(t, sc.Actions[0]), "newer cars")
+	assert.Contains(t, bodyText(t, sc.Actions[0]), "// not a comment")
+	assert.Contains(t, bodyText(t, sc.Actions[0]), "\n  \"script\": {\n")
+
+	raw, err := readScript("../tests/commented.js")
+	require.NoError(t, err)
+	assert.Empty(t, diagnose(raw))
+}
+
+func TestDiagnoseBadJSONAfterComments(t *testing.T) {
+	diags := diagnoseContent(t, "PUT\nfoo\n{\n  /* a\n  b */\n  \"a\": 1, // one\n  \"b\": ,\n}")
+	require.Len(t, diags, 1)
+	assert.Equal(t, RuleBadJSON, diags[0].Rule)
+	assert.Equal(t, 7, diags[0].Line)
+}
@@ -0,0 +1,111 @@
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ndjsonEndpoints are the APIs that take newline delimited JSON bodies
+var ndjsonEndpoints = []string{"_bulk", "_msearch", "_msearch/template"}
+
+// isNDJSONURL determines if the url is an API that takes newline delimited JSON
+func isNDJSONURL(url string) bool {
+	path := strings.TrimSuffix(strings.SplitN(url, "?", 2)[0], "/")
+	for _, e := range ndjsonEndpoints {
+		if path == e || strings.HasSuffix(path, "/"+e) {
+			return true
+		}
+	}
+	return false
+}
+
+// ndjsonValid ensures every line of the body is a JSON object.
+// Returns the 1 based line number of the first invalid line
+func ndjsonValid(body string) (int, error) {
+	for i, line := range strings.Split(body, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var js map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &js); err != nil {
+			return i + 1, err
+		}
+	}
+	return 0, nil
+}
+
+// ndjsonReader drops the blank lines of a _bulk or _msearch body while it
+// is streamed and ends every line with \n, as those APIs expect
+type ndjsonReader struct {
+	scanner *lineScanner
+	out     []byte //the current line not read yet
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	return &ndjsonReader{scanner: newLineScanner(r)}
+}
+
+func (r *ndjsonReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if !r.scanner.Scan() {
+			if err := r.scanner.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		if strings.TrimSpace(r.scanner.Text()) != "" {
+			r.out = []byte(r.scanner.Text() + "\n")
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// checkNDJSONResponse looks for failures inside a _bulk or _msearch response
+// which returns 200 even when individual items fail
+func checkNDJSONResponse(body []byte) error {
+	var resp struct {
+		Errors    bool                          `json:"errors"`
+		Items     []map[string]ndjsonItemResult `json:"items"`
+		Responses []ndjsonItemResult            `json:"responses"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil //not a bulk or msearch response, nothing to inspect
+	}
+
+	failed, first := 0, ""
+	check := func(i int, r ndjsonItemResult) {
+		if r.Error != nil {
+			failed++
+			if first == "" {
+				first = fmt.Sprintf("item %d: %d %s", i, r.Status, r.Error)
+			}
+		}
+	}
+	if resp.Errors {
+		for i, item := range resp.Items {
+			for _, r := range item {
+				check(i, r)
+			}
+		}
+	}
+	for i, r := range resp.Responses {
+		check(i, r)
+	}
+
+	if resp.Errors || failed > 0 {
+		return ErrSchemaChange{
+			Message: fmt.Sprintf("%d item(s) failed, first failure %s", failed, first),
+		}
+	}
+	return nil
+}
+
+// ndjsonItemResult is the result of a single item of a _bulk or _msearch request
+type ndjsonItemResult struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
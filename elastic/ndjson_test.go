@@ -0,0 +1,115 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsNDJSONURL(t *testing.T) {
+	assert.True(t, isNDJSONURL("_bulk"))
+	assert.True(t, isNDJSONURL("makes/_bulk?refresh=true"))
+	assert.True(t, isNDJSONURL("makes/_msearch"))
+	assert.True(t, isNDJSONURL("_msearch/template/"))
+	assert.False(t, isNDJSONURL("makes/_doc/_bulk_import"))
+	assert.False(t, isNDJSONURL("makes"))
+}
+
+func TestNDJSONBody(t *testing.T) {
+	sc, err := NewSchemaChange("../tests", "../tests/bulk.js", 1, 0)
+	require.NoError(t, err)
+	require.Len(t, sc.Actions, 1)
+	a := sc.Actions[0]
+	assert.Equal(t, "application/x-ndjson", a.ContentType())
+	assert.Equal(t, "{ \"index\": { \"_id\": \"1\" } }\n{ \"name\": \"Ford\" }\n{ \"index\": { \"_id\": \"2\" } }\n{ \"name\": \"Toyota\" }\n", bodyText(t, a))
+	assert.NoError(t, a.Validate())
+
+	a.bodyFile, a.JSON = "", "{ \"index\": {} }\n{ \"name\": }\n"
+	assert.Equal(t, ErrBadJSON, a.Validate())
+}
+
+func TestDiagnoseNDJSONLine(t *testing.T) {
+	diags := diagnoseContent(t, "POST\n_bulk\n{ \"index\": {} }\n{ \"name\": \"Ford\" }\n{ \"index\": {} }\n{ \"name\": }")
+	require.Len(t, diags, 1)
+	assert.Equal(t, RuleBadJSON, diags[0].Rule)
+	assert.Equal(t, 6, diags[0].Line)
+}
+
+func TestYAMLScriptNDJSONBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := writeScript(t, dir, "test/01.yml", "verb: POST\nurl: _bulk\nbody:\n  - index: { _id: 1 }\n  - name: Ford\n")
+	sc, err := NewSchemaChange(dir, file, 1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"index\":{\"_id\":1}}\n{\"name\":\"Ford\"}\n", sc.Actions[0].JSON)
+
+	//a list body for other urls is still a JSON array
+	file = writeScript(t, dir, "test/02.yml", "verb: PUT\nbody:\n  - 1\n  - 2\nurl: foo/_doc/1\n")
+	sc, err = NewSchemaChange(dir, file, 1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "[1,2]", sc.Actions[0].JSON)
+}
+
+func TestApplyBulkItemErrors(t *testing.T) {
+	var contentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/makes/_bulk" {
+			contentType = r.Header.Get("Content-Type")
+			w.Write([]byte(`{"errors":true,"items":[{"index":{"status":201}},` +
+				`{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+			return
+		}
+		w.Write([]byte(`{"acknowledged":true}`))
+	}))
+	defer ts.Close()
+
+	sc, err := NewSchemaChange("../tests", "../tests/bulk.js", 1, 0)
+	require.NoError(t, err)
+	changer, err := NewEsSchemaChanger(ts.URL, Creds{}, false)
+	require.NoError(t, err)
+
+	_, err = changer.Apply(sc)
+	require.Error(t, err)
+	assert.Equal(t, "application/x-ndjson", contentType)
+	assert.Contains(t, err.Error(), "1 item(s) failed")
+	assert.Contains(t, err.Error(), "mapper_parsing_exception")
+}
+
+func TestApplyBulkItemErrorsWithAssertion(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/makes/_bulk" {
+			w.Write([]byte(`{"took":3,"errors":true,"items":[{"index":{"status":201}},` +
+				`{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+			return
+		}
+		w.Write([]byte(`{"acknowledged":true}`))
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := writeScript(t, dir, "bulk.js", "POST\nmakes/_bulk\n{ \"index\": { \"_id\": \"1\" } }\n{ \"name\": \"Ford\" }\nASSERT\n{ \"exists\": [\"$.took\"] }")
+
+	sc, err := NewSchemaChange(dir, file, 1, 0)
+	require.NoError(t, err)
+	require.NotNil(t, sc.Actions[0].Assert)
+	changer, err := NewEsSchemaChanger(ts.URL, Creds{}, false)
+	require.NoError(t, err)
+
+	_, err = changer.Apply(sc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 item(s) failed")
+}
+
+func TestCheckNDJSONResponse(t *testing.T) {
+	assert.NoError(t, checkNDJSONResponse([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`)))
+	assert.NoError(t, checkNDJSONResponse([]byte(`{"responses":[{"hits":{},"status":200}]}`)))
+	assert.Error(t, checkNDJSONResponse([]byte(`{"responses":[{"error":{"type":"index_not_found_exception"},"status":404}]}`)))
+}
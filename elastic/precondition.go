@@ -0,0 +1,227 @@
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// whenKeyword is the line that starts the optional precondition
+// block of a schema file
+const whenKeyword = "WHEN"
+
+// What the Runner does with a schema change whose precondition is not met
+const (
+	UnmetSkip = "skip" //Leave it unapplied, it is checked again next deploy
+	UnmetMark = "mark" //Record it as applied without running it
+	UnmetFail = "fail" //Stop the deployment with ErrPrecondition
+)
+
+// Precondition must be met before a schema change is applied. All of the
+// conditions that are set must be true
+//
+//	{
+//	    "indexExists": "cars_v1",
+//	    "aliasMissing": "cars",
+//	    "version": ">=7.10.0 <8.0.0",
+//	    "env": { "REGION": "us" },
+//	    "profiles": [ "staging", "production" ],
+//	    "onUnmet": "skip"
+//	}
+type Precondition struct {
+	IndexExists     string            `json:"indexExists"`
+	IndexMissing    string            `json:"indexMissing"`
+	AliasExists     string            `json:"aliasExists"`
+	AliasMissing    string            `json:"aliasMissing"`
+	TemplateExists  string            `json:"templateExists"`
+	TemplateMissing string            `json:"templateMissing"`
+	Version         string            `json:"version"`  //Space separated constraints on the cluster version
+	Env             map[string]string `json:"env"`      //Environment variables and the value they must have
+	Profiles        []string          `json:"profiles"` //Deployment profiles the script runs for
+	OnUnmet         string            `json:"onUnmet"`  //skip (default), mark or fail
+}
+
+// parsePrecondition will decode a precondition block and verify it is well formed
+func parsePrecondition(text string) (*Precondition, error) {
+	p := new(Precondition)
+	if err := json.Unmarshal([]byte(text), p); err != nil {
+		return nil, err
+	}
+	switch p.OnUnmet {
+	case "":
+		p.OnUnmet = UnmetSkip
+	case UnmetSkip, UnmetMark, UnmetFail:
+	default:
+		return nil, fmt.Errorf("onUnmet must be %s, %s or %s but was %q", UnmetSkip, UnmetMark, UnmetFail, p.OnUnmet)
+	}
+	if _, err := parseVersionRange(p.Version); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Check evaluates the precondition against the cluster and the local environment.
+// Returns if the precondition is met and when not the reason why
+func (p *Precondition) Check(sc SchemaChanger, profile string) (bool, string, error) {
+	if len(p.Profiles) > 0 && !contains(p.Profiles, profile) {
+		return false, fmt.Sprintf("profile %q is not one of %s", profile, strings.Join(p.Profiles, ", ")), nil
+	}
+	for k, v := range p.Env {
+		if os.Getenv(k) != v {
+			return false, fmt.Sprintf("environment variable %s is not %q", k, v), nil
+		}
+	}
+
+	checks := []struct {
+		kind   string
+		name   string
+		exists bool
+	}{
+		{"index", p.IndexExists, true},
+		{"index", p.IndexMissing, false},
+		{"alias", p.AliasExists, true},
+		{"alias", p.AliasMissing, false},
+		{"template", p.TemplateExists, true},
+		{"template", p.TemplateMissing, false},
+	}
+	for _, c := range checks {
+		if c.name == "" {
+			continue
+		}
+		found, err := sc.Exists(c.kind, c.name)
+		if err != nil {
+			return false, "", err
+		}
+		if found != c.exists {
+			state := "does not exist"
+			if found {
+				state = "exists"
+			}
+			return false, fmt.Sprintf("%s %s %s", c.kind, c.name, state), nil
+		}
+	}
+
+	if p.Version != "" {
+		v, err := sc.Version()
+		if err != nil {
+			return false, "", err
+		}
+		constraints, _ := parseVersionRange(p.Version)
+		ok, err := constraints.matches(v)
+		if err != nil {
+			return false, "", err
+		}
+		if !ok {
+			return false, fmt.Sprintf("cluster version %s does not match %s", v, p.Version), nil
+		}
+	}
+	return true, "", nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// versionConstraint is a single comparison such as >=7.10.0. With = a
+// partial version is a prefix, so =7 matches every 7.x.y
+type versionConstraint struct {
+	op      string
+	version [3]int
+	parts   int //parts of the version given
+}
+
+type versionRange []versionConstraint
+
+// parseVersionRange parses space separated constraints (>=7.0 <8)
+func parseVersionRange(text string) (versionRange, error) {
+	var r versionRange
+	for _, part := range strings.Fields(text) {
+		op := "="
+		for _, o := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(part, o) {
+				op = o
+				break
+			}
+		}
+		v, parts, err := parseVersion(strings.TrimPrefix(part, op))
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q", part)
+		}
+		r = append(r, versionConstraint{op, v, parts})
+	}
+	return r, nil
+}
+
+// parseVersion parses major.minor.patch and returns the number of parts
+// given. Missing parts default to 0 and anything after a dash
+// (7.10.0-SNAPSHOT) is ignored
+func parseVersion(text string) ([3]int, int, error) {
+	var v [3]int
+	text = strings.SplitN(text, "-", 2)[0]
+	parts := strings.Split(text, ".")
+	if len(parts) > 3 {
+		return v, 0, fmt.Errorf("invalid version %q", text)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return v, 0, fmt.Errorf("invalid version %q", text)
+		}
+		v[i] = n
+	}
+	return v, len(parts), nil
+}
+
+func (r versionRange) matches(version string) (bool, error) {
+	v, _, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range r {
+		cmp := compareVersions(v, c.version)
+		if c.op == "=" {
+			//only the parts given are compared
+			prefix := v
+			for i := c.parts; i < len(prefix); i++ {
+				prefix[i] = 0
+			}
+			cmp = compareVersions(prefix, c.version)
+		}
+		var ok bool
+		switch c.op {
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		case "=":
+			ok = cmp == 0
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionRange(t *testing.T) {
+	r, err := parseVersionRange(">=7.10 <8")
+	require.NoError(t, err)
+	for v, expected := range map[string]bool{"7.9.3": false, "7.10.0": true, "7.17.1-SNAPSHOT": true, "8.0.0": false} {
+		ok, err := r.matches(v)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, ok, v)
+	}
+
+	_, err = parseVersionRange(">=seven")
+	assert.Error(t, err)
+}
+
+func TestVersionRangePartialEquals(t *testing.T) {
+	for text, cases := range map[string]map[string]bool{
+		"7":    {"7.0.0": true, "7.10.2": true, "6.8.0": false, "8.0.0": false},
+		"=7":   {"7.10.2": true, "8.1.0": false},
+		"=7.1": {"7.1.0": true, "7.1.5": true, "7.10.2": false},
+	} {
+		r, err := parseVersionRange(text)
+		require.NoError(t, err)
+		for v, expected := range cases {
+			ok, err := r.matches(v)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, ok, text+" "+v)
+		}
+	}
+}
+
+func TestParsePreconditionBadOnUnmet(t *testing.T) {
+	_, err := parsePrecondition(`{ "indexExists": "foo", "onUnmet": "explode" }`)
+	assert.Error(t, err)
+}
+
+func TestPreconditionCheck(t *testing.T) {
+	f := newFakeSchemaChanger()
+	f.resources["index/cars_v1"] = true
+
+	p, err := parsePrecondition(`{ "indexExists": "cars_v1", "aliasMissing": "cars", "version": ">=7.0.0", "profiles": ["staging"] }`)
+	require.NoError(t, err)
+	assert.Equal(t, UnmetSkip, p.OnUnmet)
+
+	met, _, err := p.Check(f, "staging")
+	assert.NoError(t, err)
+	assert.True(t, met)
+
+	met, reason, err := p.Check(f, "production")
+	assert.NoError(t, err)
+	assert.False(t, met)
+	assert.Contains(t, reason, "profile")
+
+	f.resources["alias/cars"] = true
+	met, reason, _ = p.Check(f, "staging")
+	assert.False(t, met)
+	assert.Equal(t, "alias cars exists", reason)
+}
+
+func TestPreconditionEnv(t *testing.T) {
+	os.Setenv("ESDEPLOY_TEST_REGION", "us")
+	defer os.Unsetenv("ESDEPLOY_TEST_REGION")
+
+	p, err := parsePrecondition(`{ "env": { "ESDEPLOY_TEST_REGION": "eu" } }`)
+	require.NoError(t, err)
+	met, _, err := p.Check(newFakeSchemaChanger(), "")
+	assert.NoError(t, err)
+	assert.False(t, met)
+}
+
+func TestDeployPreconditionOnUnmet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeScript(t, dir, "cars/01.js", "PUT\ncars_v2\nWHEN\n{ \"indexExists\": \"cars_v1\" }")
+	writeScript(t, dir, "cars/02.js", "PUT\ncars_v3\nWHEN\n{ \"indexExists\": \"cars_v1\", \"onUnmet\": \"mark\" }")
+
+	f := newFakeSchemaChanger()
+	results, err := NewRunner(dir, f).Deploy(1, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Contains(t, results[0], "Skipped (index cars_v1 does not exist)")
+	assert.Contains(t, results[1], "Marked applied")
+	assert.False(t, f.applied["cars/01.js"])
+	assert.True(t, f.applied["cars/02.js"])
+
+	writeScript(t, dir, "cars/03.js", "PUT\ncars_v4\nWHEN\n{ \"indexExists\": \"cars_v1\", \"onUnmet\": \"fail\" }")
+	_, err = NewRunner(dir, f).Deploy(1, 0)
+	assert.IsType(t, ErrPrecondition{}, err)
+
+	f.resources["index/cars_v1"] = true
+	_, err = NewRunner(dir, f).Deploy(1, 0)
+	assert.NoError(t, err)
+	assert.True(t, f.applied["cars/01.js"])
+	assert.True(t, f.applied["cars/03.js"])
+}
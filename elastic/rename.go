@@ -0,0 +1,102 @@
+package elastic
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// renameFile lists scripts that were renamed or moved, one per line with
+// the previous ID and the current ID (old/01.js -> cars/01.js). It is read
+// from the root of the scripts folder, lines starting with # are comments
+const renameFile = ".esdeployrenames"
+
+// readRenameFile reads the rename file of dir into a map of each current
+// ID to its previous IDs
+func readRenameFile(dir string) (map[string][]string, error) {
+	renames := make(map[string][]string)
+	path := filepath.Join(dir, renameFile)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return renames, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.Split(text, "->")
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, ErrScriptFile{File: path, Line: line, Err: fmt.Errorf("expecting previous ID -> current ID but was %q", text)}
+		}
+		current := strings.TrimSpace(parts[1])
+		renames[current] = append(renames[current], strings.TrimSpace(parts[0]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, ErrScriptFile{File: path, Err: err}
+	}
+	return renames, nil
+}
+
+// previousIDs are the IDs a schema change was known by before it was renamed
+// or moved, from its previous header and the rename file
+func (r *Runner) previousIDs(s *SchemaChange) []string {
+	var ids []string
+	if s.Header != nil {
+		ids = append(ids, s.Header.PreviousIDs...)
+	}
+	return append(ids, r.renames[s.ID]...)
+}
+
+// RenameIDs moves the records of schema changes applied under one of their
+// previous IDs to their current ID, so the previous IDs can be removed
+func (r *Runner) RenameIDs() ([]string, error) {
+	var results []string
+	files, err := r.getFiles()
+	if err != nil {
+		return results, err
+	}
+	for _, file := range files {
+		s, err := NewSchemaChange(r.Directory, file, -1, -1)
+		if err != nil {
+			results = append(results, "Error: "+file)
+			return results, err
+		}
+		for _, old := range r.previousIDs(s) {
+			applied, err := r.SchemaChanger.WasApplied(old)
+			if err != nil {
+				results = append(results, "Error: "+s.ID)
+				return results, err
+			}
+			if !applied {
+				continue
+			}
+			current, err := r.SchemaChanger.WasApplied(s.ID)
+			if err != nil {
+				results = append(results, "Error: "+s.ID)
+				return results, err
+			}
+			if current {
+				results = append(results, fmt.Sprintf("Skipped (already recorded, %s left as is): %s", old, s.ID))
+				break
+			}
+			if err := r.SchemaChanger.Rename(old, s); err != nil {
+				results = append(results, "Error: "+s.ID)
+				return results, err
+			}
+			results = append(results, fmt.Sprintf("Renamed: %s -> %s", old, s.ID))
+			break
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,94 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRenameFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	renames, err := readRenameFile(dir)
+	require.NoError(t, err)
+	assert.Empty(t, renames)
+
+	writeScript(t, dir, renameFile, "# moved into the cars folder\nold/01.js -> cars/01.js\ncars-01.js->cars/01.js\n")
+	renames, err = readRenameFile(dir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"cars/01.js": {"old/01.js", "cars-01.js"}}, renames)
+
+	writeScript(t, dir, renameFile, "old/01.js cars/01.js\n")
+	_, err = readRenameFile(dir)
+	require.IsType(t, ErrScriptFile{}, err)
+	assert.Equal(t, 1, err.(ErrScriptFile).Line)
+}
+
+func TestDeployPreviousIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeScript(t, dir, "cars/01.js", "// previous: old/01.js\nPUT\ncars\n{}")
+	writeScript(t, dir, "cars/02.js", "PUT\ncars/_mapping\n{}")
+	writeScript(t, dir, renameFile, "old/02.js -> cars/02.js\n")
+
+	f := newFakeSchemaChanger()
+	f.applied["old/01.js"] = true
+	f.applied["old/02.js"] = true
+	r := NewRunner(dir, f)
+
+	results, err := r.Deploy(1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Skipped: cars/01.js", "Skipped: cars/02.js"}, results)
+
+	results, err = r.RenameIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Renamed: old/01.js -> cars/01.js", "Renamed: old/02.js -> cars/02.js"}, results)
+	assert.True(t, f.applied["cars/01.js"])
+	assert.False(t, f.applied["old/01.js"])
+	assert.Equal(t, "02.js", f.infos["cars/02.js"].File)
+
+	_, err = r.Deploy(1, 0)
+	require.NoError(t, err)
+	assert.Empty(t, f.runs)
+}
+
+func TestDeployDependsOnPreviousIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeScript(t, dir, "cars/01.js", "// previous: old/01.js\nPUT\ncars\n{}")
+	writeScript(t, dir, "cars/02.js", "// depends: cars/01.js\nPUT\ncars/_mapping\n{}")
+	writeScript(t, dir, "cars/03.js", "// depends: cars/04.js\nPUT\ncars/_settings\n{}")
+	writeScript(t, dir, "cars/04.js", "PUT\ncars/_alias/autos\n{}")
+	writeScript(t, dir, renameFile, "old/04.js -> cars/04.js\n")
+
+	//applied before the renames, which have not been recorded yet
+	f := newFakeSchemaChanger()
+	f.applied["old/01.js"] = true
+	f.applied["old/04.js"] = true
+	results, err := NewRunner(dir, f).Deploy(1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Skipped: cars/01.js", "Applied: cars/02.js", "Applied: cars/03.js", "Skipped: cars/04.js"}, results)
+}
+
+func TestValidatePreviousIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeScript(t, dir, "cars/01.js", "PUT\ncars\n{}")
+	writeScript(t, dir, "cars/02.js", "// previous: cars/01.js\nPUT\ncars/_mapping\n{}")
+
+	results, err := NewRunner(dir, nil).Validate()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.True(t, results[0].IsValid)
+	assert.False(t, results[1].IsValid)
+	assert.Equal(t, RuleDuplicateID, results[1].Diagnostics[0].Rule)
+	assert.Equal(t, 1, results[1].Diagnostics[0].Line)
+}
@@ -1,22 +1,20 @@
 package elastic
 
 import (
+	"errors"
 	"fmt"
-	"log"
-	"os"
 	"path/filepath"
+	"strings"
 )
 
-// ValidationResult is the result of validating a schema file
-type ValidationResult struct {
-	File    string
-	IsValid bool
-}
-
 // Runner handles the coordination of applying elastic search schema changes
 type Runner struct {
 	SchemaChanger SchemaChanger
 	Directory     string
+	Profile       string     //Deployment profile matched against script preconditions
+	Filter        FileFilter //Which files in Directory are schema files
+
+	renames map[string][]string //previous IDs of each script from the rename file
 }
 
 // NewRunner will initialize a new Runner
@@ -34,48 +32,264 @@ func NewRunner(directory string, schemaChanger SchemaChanger) *Runner {
 // they are valid and apply the changes to elastic search
 func (r *Runner) Deploy(shards, replicas int) ([]string, error) {
 	var results []string
-	files := getFiles(r.Directory)
+	files, err := r.getFiles()
+	if err != nil {
+		return results, err
+	}
 	for _, file := range files {
-		s := NewSchemaChange(file, shards, replicas)
+		s, err := NewSchemaChange(r.Directory, file, shards, replicas)
+		if err != nil {
+			results = append(results, "Error: "+file)
+			return results, err
+		}
 
-		applied, err := r.SchemaChanger.WasApplied(s.ID)
+		applied, err := r.isApplied(s)
 		if err != nil {
 			return results, err
 		}
-		path := fmt.Sprintf("%s\\%s", s.Folder, s.FileName)
+		path := s.ID
 		if applied {
 			results = append(results, "Skipped: "+path)
-		} else {
-			err := r.SchemaChanger.Apply(s)
-			if err != nil {
+			continue
+		}
+
+		if err := r.checkLegacyID(file, s); err != nil {
+			results = append(results, "Error: "+path)
+			return results, err
+		}
+
+		if err := r.checkDependencies(s); err != nil {
+			results = append(results, "Error: "+path)
+			return results, err
+		}
+
+		met, reason, err := r.checkPrecondition(s)
+		if err != nil {
+			results = append(results, "Error: "+path)
+			return results, err
+		}
+		if !met {
+			switch s.Precondition.OnUnmet {
+			case UnmetMark:
+				if err := r.SchemaChanger.MarkApplied(s); err != nil {
+					results = append(results, "Error: "+path)
+					return results, err
+				}
+				results = append(results, "Marked applied ("+reason+"): "+path)
+			case UnmetFail:
 				results = append(results, "Error: "+path)
+				return results, ErrPrecondition{ID: s.ID, Reason: reason}
+			default:
+				results = append(results, "Skipped ("+reason+"): "+path)
+			}
+			continue
+		}
+
+		steps, err := r.SchemaChanger.Apply(s)
+		if err != nil {
+			results = append(results, "Error: "+path)
+			results = append(results, stepResults(steps)...)
+			return results, err
+		}
+		results = append(results, "Applied: "+path)
+		if len(s.Actions) > 1 {
+			results = append(results, stepResults(steps)...)
+		}
+	}
+	return results, nil
+}
+
+// stepResults formats the result of each step for display under the schema change
+func stepResults(steps []StepResult) []string {
+	var results []string
+	for i, step := range steps {
+		results = append(results, fmt.Sprintf("    step %d: %v", i+1, step))
+	}
+	return results
+}
+
+// isApplied determines if the schema change is already applied based on its
+// run mode. Always scripts are never applied and on change scripts are only
+// applied if the file content is the same as when it was last applied.
+// A schema change applied under one of its previous IDs is applied
+func (r *Runner) isApplied(s *SchemaChange) (bool, error) {
+	if s.RunMode == RunAlways {
+		return false, nil
+	}
+	for _, id := range append([]string{s.ID}, r.previousIDs(s)...) {
+		applied, err := r.appliedAs(s, id)
+		if err != nil || applied {
+			return applied, err
+		}
+	}
+	return false, nil
+}
+
+// appliedAs determines if the schema change is applied under id
+func (r *Runner) appliedAs(s *SchemaChange, id string) (bool, error) {
+	if s.RunMode == RunOnChange {
+		info, err := r.SchemaChanger.GetVersionInfo(id)
+		if err != nil || info == nil {
+			return false, err
+		}
+		return info.Hash == s.Hash && !info.Partial, nil
+	}
+	return r.SchemaChanger.WasApplied(id)
+}
+
+// checkLegacyID stops a schema change recorded under its legacy ID from
+// being applied a second time before the IDs are migrated
+func (r *Runner) checkLegacyID(file string, s *SchemaChange) error {
+	if s.RunMode == RunAlways {
+		return nil
+	}
+	legacy := legacySchemaID(file)
+	applied, err := r.SchemaChanger.WasApplied(legacy)
+	if err != nil {
+		return err
+	}
+	if applied {
+		return ErrLegacyID{ID: s.ID, LegacyID: legacy}
+	}
+	return nil
+}
+
+// MigrateIDs moves the records of schema changes applied under their legacy
+// ID (folder-file) to the ID based on the path relative to Directory. When
+// several files had the same legacy ID the record stays with the first in
+// order, which is the one that was applied. A legacy record whose owner is
+// already recorded under its new ID is removed, as it would stop the other
+// files with the same legacy ID from being applied
+func (r *Runner) MigrateIDs() ([]string, error) {
+	var results []string
+	files, err := r.getFiles()
+	if err != nil {
+		return results, err
+	}
+	owners := make(map[string]string)
+	for _, file := range files {
+		folder, filename, id := schemaID(r.Directory, file)
+		legacy := legacySchemaID(file)
+		if owner, ok := owners[legacy]; ok {
+			results = append(results, fmt.Sprintf("Skipped (%s belongs to %s): %s", legacy, owner, id))
+			continue
+		}
+		owners[legacy] = id
+
+		applied, err := r.SchemaChanger.WasApplied(legacy)
+		if err != nil {
+			results = append(results, "Error: "+id)
+			return results, err
+		}
+		if !applied {
+			continue
+		}
+		migrated, err := r.SchemaChanger.WasApplied(id)
+		if err != nil {
+			results = append(results, "Error: "+id)
+			return results, err
+		}
+		if migrated {
+			if err := r.SchemaChanger.Delete(legacy); err != nil {
+				results = append(results, "Error: "+id)
 				return results, err
 			}
-			results = append(results, "Applied: "+path)
+			results = append(results, fmt.Sprintf("Removed (already recorded as %s): %s", id, legacy))
+			continue
+		}
+		s := &SchemaChange{Folder: folder, FileName: filename, ID: id}
+		if err := r.SchemaChanger.Rename(legacy, s); err != nil {
+			results = append(results, "Error: "+id)
+			return results, err
 		}
+		results = append(results, fmt.Sprintf("Migrated: %s -> %s", legacy, id))
 	}
 	return results, nil
 }
 
+// checkDependencies ensures every schema change listed in the
+// depends header has already been applied, under its ID or one of its
+// previous IDs
+func (r *Runner) checkDependencies(s *SchemaChange) error {
+	if s.Header == nil {
+		return nil
+	}
+	for _, dep := range s.Header.DependsOn {
+		applied, err := r.dependencyApplied(dep)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return ErrDependency{ID: s.ID, DependsOn: dep}
+		}
+	}
+	return nil
+}
+
+// dependencyApplied determines if the schema change with the ID was applied
+// under it or, like isApplied, under one of the previous IDs in its header
+// or the rename file
+func (r *Runner) dependencyApplied(id string) (bool, error) {
+	dep := &SchemaChange{ID: id}
+	if sc, err := readScript(filepath.Join(r.Directory, filepath.FromSlash(id))); err == nil {
+		dep.Header, _, _ = parseHeader(sc.Header, sc.HeaderLine)
+	}
+	for _, id := range append([]string{id}, r.previousIDs(dep)...) {
+		applied, err := r.SchemaChanger.WasApplied(id)
+		if err != nil || applied {
+			return applied, err
+		}
+	}
+	return false, nil
+}
+
+// checkPrecondition determines if the schema change can be applied.
+// Schema changes without a precondition are always met
+func (r *Runner) checkPrecondition(s *SchemaChange) (bool, string, error) {
+	if s.Precondition == nil {
+		return true, "", nil
+	}
+	return s.Precondition.Check(r.SchemaChanger, r.Profile)
+}
+
 // DryRun will examine all of the files, verify
 // they are valid and ONLY list out the changes that
 // would be applied to elastic search
 func (r *Runner) DryRun() ([]string, error) {
 	var results []string
-	files := getFiles(r.Directory)
+	files, err := r.getFiles()
+	if err != nil {
+		return results, err
+	}
 	for _, file := range files {
-		s := NewSchemaChange(file, -1, -1)
-		err := s.Action.Validate()
+		s, err := NewSchemaChange(r.Directory, file, -1, -1)
 		if err != nil {
 			return nil, err
 		}
-		applied, err := r.SchemaChanger.WasApplied(s.ID)
+		for _, a := range s.Actions {
+			err = a.Validate()
+			if err != nil {
+				return nil, ErrScriptFile{File: file, Err: err}
+			}
+		}
+		applied, err := r.isApplied(s)
 		if err != nil {
 			return results, err
 		}
-		path := fmt.Sprintf("%s\\%s", s.Folder, s.FileName)
+		path := s.ID
 		if applied {
 			results = append(results, "Skip: "+path)
+			continue
+		}
+		if err := r.checkLegacyID(file, s); err != nil {
+			return results, err
+		}
+		met, reason, err := r.checkPrecondition(s)
+		if err != nil {
+			return results, err
+		}
+		if !met {
+			results = append(results, fmt.Sprintf("Precondition not met, would %s (%s): %s", s.Precondition.OnUnmet, reason, path))
 		} else {
 			results = append(results, "Apply: "+path)
 		}
@@ -84,32 +298,99 @@ func (r *Runner) DryRun() ([]string, error) {
 }
 
 //Validate will ensure all schema files are following
-//the required format and are valid
-func (r *Runner) Validate() []ValidationResult {
+//the required format and are valid. Every problem found
+//is reported as a diagnostic on the file's result
+func (r *Runner) Validate() ([]ValidationResult, error) {
 	var results []ValidationResult
-	files := getFiles(r.Directory)
-	for _, file := range files {
-		s := NewSchemaChange(file, -1, -1)
-		err := s.Action.Validate()
+	ids := make(map[string]string)
+	folded := make(map[string]string)
+	headers := make(map[int]*Header)
+	var fileIDs []string
+	files, err := r.getFiles()
+	if err != nil {
+		return nil, err
+	}
+	for i, file := range files {
+		result := ValidationResult{File: file}
+		sc, err := readScript(file)
 		if err != nil {
-			results = append(results, ValidationResult{File: file, IsValid: false})
-			continue
+			d := Diagnostic{Severity: SeverityError, Rule: RuleUnreadable, Message: err.Error()}
+			var fileErr ErrScriptFile
+			if errors.As(err, &fileErr) {
+				d.Line = fileErr.Line
+				d.Message = fileErr.Err.Error()
+			}
+			if errors.Is(err, ErrBadYAML) {
+				d.Rule = RuleBadYAML
+			}
+			result.Diagnostics = append(result.Diagnostics, d)
+		} else {
+			result.Diagnostics = append(result.Diagnostics, diagnose(sc)...)
+			headers[i], _, _ = parseHeader(sc.Header, sc.HeaderLine)
+		}
+
+		//IDs that only differ by case collide when checked out on Windows or macOS
+		_, _, id := schemaID(r.Directory, file)
+		if other, ok := folded[strings.ToLower(id)]; ok {
+			result.Diagnostics = append(result.Diagnostics, Diagnostic{
+				Severity: SeverityError,
+				Rule:     RuleDuplicateID,
+				Message:  fmt.Sprintf("ID %s collides with %s", id, other),
+			})
+		} else {
+			folded[strings.ToLower(id)] = file
 		}
-		results = append(results, ValidationResult{File: file, IsValid: true})
+		ids[id] = file
+		fileIDs = append(fileIDs, id)
+		results = append(results, result)
 	}
-	return results
-}
 
-func getFiles(dir string) []string {
-	fileList := []string{}
-	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
-		if filepath.Ext(path) == ".js" {
-			fileList = append(fileList, path)
+	//dependencies and previous IDs can only be checked once every ID is known
+	previous := make(map[string]string)
+	for i := range results {
+		s := &SchemaChange{ID: fileIDs[i], Header: headers[i]}
+		for _, old := range r.previousIDs(s) {
+			owner, ok := ids[old]
+			if !ok {
+				owner, ok = previous[old]
+			}
+			if ok && owner != files[i] {
+				line := 0
+				if h := headers[i]; h != nil {
+					line = h.lines["previous"]
+				}
+				results[i].Diagnostics = append(results[i].Diagnostics, Diagnostic{
+					Severity: SeverityError,
+					Rule:     RuleDuplicateID,
+					Line:     line,
+					Message:  fmt.Sprintf("previous ID %s is also used by %s", old, owner),
+				})
+			}
+			previous[old] = files[i]
 		}
-		return nil
-	})
+		if h := headers[i]; h != nil {
+			for _, dep := range h.DependsOn {
+				if _, ok := ids[dep]; !ok {
+					results[i].Diagnostics = append(results[i].Diagnostics, Diagnostic{
+						Severity: SeverityError,
+						Rule:     RuleUnknownDependency,
+						Line:     h.lines["depends"],
+						Message:  fmt.Sprintf("depends on %s which does not exist", dep),
+					})
+				}
+			}
+		}
+		results[i].IsValid = !hasErrors(results[i].Diagnostics)
+	}
+	return results, nil
+}
+
+// getFiles returns the schema files in Directory and reads the rename file
+func (r *Runner) getFiles() ([]string, error) {
+	renames, err := readRenameFile(r.Directory)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return fileList
+	r.renames = renames
+	return r.Filter.walk(r.Directory, isScriptFile)
 }
@@ -0,0 +1,175 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSchemaChanger records what the Runner does without an elastic search cluster
+type fakeSchemaChanger struct {
+	applied   map[string]bool
+	infos     map[string]*VersionInfo
+	runs      map[string]int
+	resources map[string]bool
+	version   string
+}
+
+func newFakeSchemaChanger() *fakeSchemaChanger {
+	return &fakeSchemaChanger{
+		applied:   make(map[string]bool),
+		infos:     make(map[string]*VersionInfo),
+		runs:      make(map[string]int),
+		resources: make(map[string]bool),
+		version:   "7.10.2",
+	}
+}
+
+func (f *fakeSchemaChanger) WasApplied(id string) (bool, error) {
+	return f.applied[id], nil
+}
+
+func (f *fakeSchemaChanger) GetVersionInfo(id string) (*VersionInfo, error) {
+	return f.infos[id], nil
+}
+
+func (f *fakeSchemaChanger) Apply(s *SchemaChange) ([]StepResult, error) {
+	f.runs[s.ID]++
+	var steps []StepResult
+	for _, a := range s.Actions {
+		steps = append(steps, StepResult{Action: a, Status: 200})
+	}
+	return steps, f.MarkApplied(s)
+}
+
+func (f *fakeSchemaChanger) MarkApplied(s *SchemaChange) error {
+	f.applied[s.ID] = true
+	f.infos[s.ID] = &VersionInfo{ID: s.ID, RunMode: s.RunMode, Hash: s.Hash}
+	return nil
+}
+
+func (f *fakeSchemaChanger) Exists(kind, name string) (bool, error) {
+	return f.resources[kind+"/"+name], nil
+}
+
+func (f *fakeSchemaChanger) Version() (string, error) {
+	return f.version, nil
+}
+
+func (f *fakeSchemaChanger) Rename(oldID string, s *SchemaChange) error {
+	if !f.applied[oldID] {
+		return nil
+	}
+	info := f.infos[oldID]
+	if info == nil {
+		info = &VersionInfo{}
+	}
+	info.ID, info.Folder, info.File = s.ID, s.Folder, s.FileName
+	f.applied[s.ID], f.infos[s.ID] = true, info
+	return f.Delete(oldID)
+}
+
+func (f *fakeSchemaChanger) Delete(id string) error {
+	delete(f.applied, id)
+	delete(f.infos, id)
+	return nil
+}
+
+func TestRunMode(t *testing.T) {
+	assert.Equal(t, RunOnce, runMode("01.001_create_index.js"))
+	assert.Equal(t, RunOnChange, runMode("02.001_pipeline.onchange.js"))
+	assert.Equal(t, RunAlways, runMode("99.001_refresh.always.js"))
+}
+
+func TestDeployRunModes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeScript(t, dir, "cars/01.js", "PUT\ncars\n{}")
+	writeScript(t, dir, "cars/02.onchange.js", "PUT\n_ingest/pipeline/cars\n{ \"processors\": [] }")
+	writeScript(t, dir, "cars/03.always.js", "POST\ncars/_refresh\n")
+
+	f := newFakeSchemaChanger()
+	r := NewRunner(dir, f)
+	_, err = r.Deploy(1, 0)
+	require.NoError(t, err)
+	_, err = r.Deploy(1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, f.runs["cars/01.js"])
+	assert.Equal(t, 1, f.runs["cars/02.onchange.js"])
+	assert.Equal(t, 2, f.runs["cars/03.always.js"])
+	assert.Equal(t, RunOnChange, f.infos["cars/02.onchange.js"].RunMode)
+
+	writeScript(t, dir, "cars/02.onchange.js", "PUT\n_ingest/pipeline/cars\n{ \"description\": \"v2\", \"processors\": [] }")
+	results, err := r.DryRun()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Skip: cars/01.js", "Apply: cars/02.onchange.js", "Apply: cars/03.always.js"}, results)
+
+	_, err = r.Deploy(1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, f.runs["cars/02.onchange.js"])
+}
+
+func TestSchemaID(t *testing.T) {
+	folder, file, id := schemaID("scripts", "scripts/a/common/01.js")
+	assert.Equal(t, "a/common", folder)
+	assert.Equal(t, "01.js", file)
+	assert.Equal(t, "a/common/01.js", id)
+
+	folder, _, id = schemaID("scripts", "scripts/01.js")
+	assert.Equal(t, "", folder)
+	assert.Equal(t, "01.js", id)
+	assert.Equal(t, "common-01.js", legacySchemaID("scripts/a/common/01.js"))
+}
+
+func TestMigrateIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeScript(t, dir, "a/common/01.js", "PUT\nfoo\n{}")
+	writeScript(t, dir, "b/common/01.js", "PUT\nbar\n{}")
+	writeScript(t, dir, "cars/01.js", "PUT\ncars\n{}")
+
+	//a/common/01.js was applied and b/common/01.js was skipped as its legacy ID matched
+	f := newFakeSchemaChanger()
+	f.applied["common-01.js"] = true
+	f.infos["common-01.js"] = &VersionInfo{ID: "common-01.js", Folder: "common", File: "01.js"}
+	r := NewRunner(dir, f)
+
+	_, err = r.Deploy(1, 0)
+	assert.Equal(t, ErrLegacyID{ID: "a/common/01.js", LegacyID: "common-01.js"}, err)
+
+	results, err := r.MigrateIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Migrated: common-01.js -> a/common/01.js",
+		"Skipped (common-01.js belongs to a/common/01.js): b/common/01.js",
+	}, results)
+	assert.False(t, f.applied["common-01.js"])
+	assert.Equal(t, "a/common", f.infos["a/common/01.js"].Folder)
+
+	_, err = r.Deploy(1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, f.runs["a/common/01.js"])
+	assert.Equal(t, 1, f.runs["b/common/01.js"])
+	assert.Equal(t, 1, f.runs["cars/01.js"])
+
+	//a legacy record left behind once its owner is recorded is removed
+	f.applied["common-01.js"] = true
+	delete(f.applied, "b/common/01.js")
+	_, err = r.Deploy(1, 0)
+	assert.Equal(t, ErrLegacyID{ID: "b/common/01.js", LegacyID: "common-01.js"}, err)
+	results, err = r.MigrateIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Removed (already recorded as a/common/01.js): common-01.js",
+		"Skipped (common-01.js belongs to a/common/01.js): b/common/01.js",
+	}, results)
+	assert.False(t, f.applied["common-01.js"])
+	assert.True(t, f.applied["a/common/01.js"])
+	_, err = r.Deploy(1, 0)
+	require.NoError(t, err)
+}
@@ -3,30 +3,39 @@ package elastic
 import (
 	"bufio"
 	"bytes"
-	"log"
-	"os"
+	"io"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 )
 
+// How often a schema change is applied
+const (
+	RunOnce     = "once"     //Applied a single time (default)
+	RunOnChange = "onchange" //Applied again whenever the file content changes (*.onchange.js)
+	RunAlways   = "always"   //Applied on every deployment (*.always.js)
+)
+
 // SchemaChange represents a schema change to apply to Elastic Search
 type SchemaChange struct {
-	Folder   string
-	FileName string
-	ID       string
-	Action   Action
-	Retrys   int
-	Shards   int //Number of shards to use per index. Only if user used tokenized value {{shards}}
-	Replicas int //Number of replicas for shards. Only if user used tokenized value {{replicas}}
+	Folder       string
+	FileName     string
+	ID           string
+	RunMode      string        //once, onchange or always
+	Hash         string        //sha256 of the schema file content
+	Actions      []Action      //Requests applied in order as a single change
+	Header       *Header       //Metadata from the comments at the top of the file
+	Precondition *Precondition //Optional, must be met before the Actions are applied
+	Shards       int           //Number of shards to use per index. Only if user used tokenized value {{shards}}
+	Replicas     int           //Number of replicas for shards. Only if user used tokenized value {{replicas}}
 }
 
-// NewSchemaChange will get keys (folder & filename)
-func NewSchemaChange(file string, shards, replicas int) *SchemaChange {
-	p := strings.Split(file, string(filepath.Separator))
-	filename := filepath.Base(file)
-	folder := p[len(p)-2]
-	id := folder + "-" + filename
+// NewSchemaChange will get keys (folder & filename) relative to the root
+// folder of the scripts and parse the file.
+// Returns ErrScriptFile if the file can not be read or parsed
+func NewSchemaChange(root, file string, shards, replicas int) (*SchemaChange, error) {
+	folder, filename, id := schemaID(root, file)
 
 	if shards <= 0 {
 		shards = 5 //default what ES 6 was doing
@@ -40,49 +49,141 @@ func NewSchemaChange(file string, shards, replicas int) *SchemaChange {
 	s.Folder = folder
 	s.FileName = filename
 	s.ID = id
+	s.RunMode = runMode(filename)
 	s.Shards = shards
 	s.Replicas = replicas
-	s.Action, s.Retrys = s.parseFile(file)
-
-	return s
+	if err := s.parseFile(file); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-func (s *SchemaChange) parseFile(esFile string) (Action, int) {
-	file, err := os.Open(esFile)
+// parseFile will read the header, Actions and precondition from a schema file
+func (s *SchemaChange) parseFile(esFile string) error {
+	sc, err := readScript(esFile)
+	if err != nil {
+		return err
+	}
+
+	header, line, err := parseHeader(sc.Header, sc.HeaderLine)
 	if err != nil {
-		log.Fatal(err)
+		return ErrScriptFile{File: esFile, Line: line, Err: err}
+	}
+	if header.RunMode != "" {
+		s.RunMode = header.RunMode
+	}
+
+	if len(sc.Steps) == 0 {
+		return ErrScriptFile{File: esFile, Err: ErrBadHTTPVerb}
+	}
+	for _, step := range sc.Steps {
+		a, line, err := s.parseStep(step, header)
+		if err != nil {
+			return ErrScriptFile{File: esFile, Line: line, Err: err}
+		}
+		s.Actions = append(s.Actions, a)
+	}
+
+	if sc.WhenLine > 0 {
+		s.Precondition, err = parsePrecondition(stripComments(strings.Join(sc.When, "\n")))
+		if err != nil {
+			return ErrScriptFile{File: esFile, Line: sc.WhenLine, Err: err}
+		}
 	}
-	defer file.Close()
 
-	//Grab the verb (line 1) and Url (line 2) from document
-	scanner := bufio.NewScanner(file)
-	scanner.Scan()
-	verb := scanner.Text()
-	scanner.Scan()
-	url := scanner.Text()
+	s.Header = header
+	s.Hash = sc.Hash
+	return nil
+}
 
-	url, retry, err := parseURL(url)
+// parseStep will build the Action for a single request of a schema file.
+// When invalid the line number of the problem is returned with the error
+func (s *SchemaChange) parseStep(step *scriptStep, header *Header) (Action, int, error) {
+	url, retry, err := parseURL(step.URL)
 	if err != nil {
-		log.Fatal(err)
+		return Action{}, step.URLLine, err
+	}
+	if header.Retries > 0 {
+		retry = header.Retries
+	}
+
+	var assert *Assertion
+	if step.AssertLine > 0 {
+		assert, err = parseAssertion(stripComments(strings.Join(step.Assert, "\n")))
+		if err != nil {
+			return Action{}, step.AssertLine, err
+		}
 	}
 
-	var body bytes.Buffer
-	for scanner.Scan() {
-		//Apply both supported token replacements if present in the file for shards and replicas
-		tmp := scanner.Text()
-		tmp = strings.ReplaceAll(tmp, "{{shards}}", strconv.Itoa(s.Shards))
-		tmp = strings.ReplaceAll(tmp, "{{replicas}}", strconv.Itoa(s.Replicas))
-		body.WriteString(tmp)
+	a := Action{
+		HTTPVerb: step.Verb,
+		URL:      strings.TrimPrefix(url, "/"),
+		Assert:   assert,
+		Retrys:   retry,
+	}
+	if step.File != "" {
+		//streamed from the file when sent, replacing the tokens as it is read
+		a.bodyFile, a.bodyOffset, a.bodyEnd = step.File, step.BodyStart, step.BodyEnd
+		a.tokens = schemaTokens{shards: s.Shards, replicas: s.Replicas}
+		body, err := a.body()
+		if err != nil {
+			return Action{}, step.BodyLine, err
+		}
+		blank, err := isBlank(body)
+		body.Close()
+		if err != nil {
+			return Action{}, step.BodyLine, err
+		}
+		if blank {
+			a.bodyFile = ""
+		}
+		return a, 0, nil
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+	//Apply both supported token replacements if present in the file for shards and replicas.
+	//The body is sent as written, less any comments
+	body := stripComments(s.replaceTokens(strings.Join(step.Body, "\n")))
+	if strings.TrimSpace(body) == "" {
+		body = ""
+	} else if isNDJSONURL(url) {
+		//blank lines are dropped for _bulk and _msearch which end with a newline
+		var ndjson bytes.Buffer
+		for _, line := range strings.Split(body, "\n") {
+			if strings.TrimSpace(line) != "" {
+				ndjson.WriteString(line)
+				ndjson.WriteString("\n")
+			}
+		}
+		body = ndjson.String()
 	}
-	return Action{
-		HTTPVerb: verb,
-		URL:      url,
-		JSON:     body.String(),
-	}, retry
+	a.JSON = body
+	return a, 0, nil
+}
+
+// replaceTokens will replace the supported {{shards}} and {{replicas}} tokens
+func (s *SchemaChange) replaceTokens(text string) string {
+	text = strings.ReplaceAll(text, "{{shards}}", strconv.Itoa(s.Shards))
+	text = strings.ReplaceAll(text, "{{replicas}}", strconv.Itoa(s.Replicas))
+	return text
+}
+
+// schemaTokens replaces the {{shards}} and {{replicas}} tokens of a body
+// streamed from a schema file, like replaceTokens
+type schemaTokens struct {
+	shards   int
+	replicas int
+}
+
+func (t schemaTokens) reader(r io.Reader) io.Reader {
+	return &tokenReader{r: bufio.NewReader(r), value: func(name string) (string, bool, error) {
+		switch name {
+		case "shards":
+			return strconv.Itoa(t.shards), true, nil
+		case "replicas":
+			return strconv.Itoa(t.replicas), true, nil
+		}
+		return "", false, nil
+	}}
 }
 
 // func parseFile(esFile string) (Action, int) {
@@ -119,6 +220,44 @@ func (s *SchemaChange) parseFile(esFile string) (Action, int) {
 // 	}, retry
 // }
 
+// runMode determines how often a schema file is applied from its name
+func runMode(filename string) string {
+	name := strings.TrimSuffix(filename, filepath.Ext(filename))
+	switch filepath.Ext(name) {
+	case "." + RunOnChange:
+		return RunOnChange
+	case "." + RunAlways:
+		return RunAlways
+	}
+	return RunOnce
+}
+
+// schemaID returns the folder, file name and unique identifier of a schema
+// file. The ID is the path relative to the root folder using / (cars/01.js)
+func schemaID(root, file string) (string, string, string) {
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		rel = file
+	}
+	rel = filepath.ToSlash(rel)
+	folder := path.Dir(rel)
+	if folder == "." {
+		folder = ""
+	}
+	return folder, path.Base(rel), rel
+}
+
+// legacySchemaID is the ID used before IDs were relative paths, the name of
+// the parent folder and the file name (cars-01.js). It was not unique when
+// folders in different places had the same name
+func legacySchemaID(file string) string {
+	folder := filepath.Base(filepath.Dir(file))
+	if folder == "." || folder == string(filepath.Separator) {
+		return filepath.Base(file)
+	}
+	return folder + "-" + filepath.Base(file)
+}
+
 //parseURL will take a URL and look for the retry option
 //Returns URL, retry count, error
 func parseURL(url string) (string, int, error) {
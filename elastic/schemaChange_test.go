@@ -1,9 +1,13 @@
 package elastic
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseUrlWithNonNumericRetry(t *testing.T) {
@@ -39,34 +43,113 @@ func TestParseUrlNoRetry(t *testing.T) {
 	assert.Equal(t, 0, retry)
 }
 
+func TestNewSchemaChangeMissingFile(t *testing.T) {
+	_, err := NewSchemaChange("../tests", "../tests/missing.js", 2, 2)
+	assert.Error(t, err)
+	assert.IsType(t, ErrScriptFile{}, err)
+}
+
+func TestNewSchemaChangeWithNonNumericRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := writeScript(t, dir, "test/01.js", "POST\nfoo/_update_by_query?retry=a\n{}")
+
+	_, err = NewSchemaChange(dir, file, 2, 2)
+	assert.Error(t, err)
+	assert.Equal(t, 2, err.(ErrScriptFile).Line)
+}
+
 func TestShardAndReplicaTokenReplacementWithNoTokens(t *testing.T) {
-	sc := NewSchemaChange("../tests/index_template.js", 2, 2)
-	assert.Contains(t, sc.Action.JSON, `"index.number_of_shards": 5`)
-	assert.Contains(t, sc.Action.JSON, `"index.number_of_replicas": 0`)
+	sc, err := NewSchemaChange("../tests", "../tests/index_template.js", 2, 2)
+	assert.NoError(t, err)
+	assert.Contains(t, bodyText(t, sc.Actions[0]), `"index.number_of_shards": 5`)
+	assert.Contains(t, bodyText(t, sc.Actions[0]), `"index.number_of_replicas": 0`)
 	assert.Equal(t, 2, sc.Shards)
 	assert.Equal(t, 2, sc.Replicas)
 }
 
 func TestShardTokenReplacementWithTokens(t *testing.T) {
-	sc := NewSchemaChange("../tests/index_template_with_shards.js", 2, 2)
-	assert.Contains(t, sc.Action.JSON, `"index.number_of_shards": 2`)
-	assert.Contains(t, sc.Action.JSON, `"index.number_of_replicas": 1`)
+	sc, err := NewSchemaChange("../tests", "../tests/index_template_with_shards.js", 2, 2)
+	assert.NoError(t, err)
+	assert.Contains(t, bodyText(t, sc.Actions[0]), `"index.number_of_shards": 2`)
+	assert.Contains(t, bodyText(t, sc.Actions[0]), `"index.number_of_replicas": 1`)
 	assert.Equal(t, 2, sc.Shards)
 	assert.Equal(t, 2, sc.Replicas)
 }
 
 func TestReplicaTokenReplacementWithNoTokens(t *testing.T) {
-	sc := NewSchemaChange("../tests/index_template_with_replicas.js", 2, 2)
-	assert.Contains(t, sc.Action.JSON, `"index.number_of_shards": 3`)
-	assert.Contains(t, sc.Action.JSON, `"index.number_of_replicas": 2`)
+	sc, err := NewSchemaChange("../tests", "../tests/index_template_with_replicas.js", 2, 2)
+	assert.NoError(t, err)
+	assert.Contains(t, bodyText(t, sc.Actions[0]), `"index.number_of_shards": 3`)
+	assert.Contains(t, bodyText(t, sc.Actions[0]), `"index.number_of_replicas": 2`)
 	assert.Equal(t, 2, sc.Shards)
 	assert.Equal(t, 2, sc.Replicas)
 }
 
 func TestShardAndReplicaTokenReplacementWithTokens(t *testing.T) {
-	sc := NewSchemaChange("../tests/index_template_with_shards_replicas.js", 2, 2)
-	assert.Contains(t, sc.Action.JSON, `"index.number_of_shards": 2`)
-	assert.Contains(t, sc.Action.JSON, `"index.number_of_replicas": 2`)
+	sc, err := NewSchemaChange("../tests", "../tests/index_template_with_shards_replicas.js", 2, 2)
+	assert.NoError(t, err)
+	assert.Contains(t, bodyText(t, sc.Actions[0]), `"index.number_of_shards": 2`)
+	assert.Contains(t, bodyText(t, sc.Actions[0]), `"index.number_of_replicas": 2`)
 	assert.Equal(t, 2, sc.Shards)
 	assert.Equal(t, 2, sc.Replicas)
 }
+
+func TestMultipleRequests(t *testing.T) {
+	sc, err := NewSchemaChange("../tests", "../tests/multiple_requests.js", 2, 2)
+	require.NoError(t, err)
+	require.Len(t, sc.Actions, 3)
+
+	assert.Equal(t, "PUT", sc.Actions[0].HTTPVerb)
+	assert.Equal(t, "cars_v1", sc.Actions[0].URL)
+	assert.Contains(t, bodyText(t, sc.Actions[0]), `"index.number_of_shards": 2`)
+	assert.Nil(t, sc.Actions[0].Assert)
+
+	assert.Equal(t, "POST", sc.Actions[1].HTTPVerb)
+	assert.Equal(t, "_aliases", sc.Actions[1].URL)
+	assert.NotNil(t, sc.Actions[1].Assert)
+
+	assert.Equal(t, "_ingest/pipeline/cars", sc.Actions[2].URL)
+	assert.Equal(t, 2, sc.Actions[2].Retrys)
+	assert.Equal(t, "cars_v0", sc.Precondition.IndexMissing)
+	assert.Equal(t, "Create the cars index with an alias and pipeline", sc.Header.Description)
+}
+
+func TestConsoleFile(t *testing.T) {
+	sc, err := NewSchemaChange("../tests", "../tests/console.http", 3, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "console.http", sc.ID)
+	assert.Equal(t, "Console file copied from Kibana Dev Tools", sc.Header.Description)
+	assert.Equal(t, "CARS-7", sc.Header.Ticket)
+	require.Len(t, sc.Actions, 3)
+
+	assert.Equal(t, "PUT", sc.Actions[0].HTTPVerb)
+	assert.Equal(t, "cars_v1", sc.Actions[0].URL)
+	assert.Contains(t, bodyText(t, sc.Actions[0]), `"index.number_of_shards": 3`)
+
+	assert.Equal(t, "_scripts/cars-score", sc.Actions[1].URL)
+	assert.NoError(t, sc.Actions[1].Validate())
+	var stored struct {
+		Script struct {
+			Source string `json:"source"`
+		} `json:"script"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(bodyText(t, sc.Actions[1])), &stored))
+	assert.Contains(t, stored.Script.Source, "// boost new cars\n")
+
+	assert.Equal(t, "GET", sc.Actions[2].HTTPVerb)
+	assert.Equal(t, "_cat/indices", sc.Actions[2].URL)
+	assert.Equal(t, "", bodyText(t, sc.Actions[2]))
+
+	raw, err := readScript("../tests/console.http")
+	require.NoError(t, err)
+	assert.Empty(t, diagnose(raw))
+}
+
+func TestConvertTripleQuotesKeepsLines(t *testing.T) {
+	lines := []string{`{ "source": """`, `  a "b"`, `""", "lang": "painless"`, `}`}
+	converted := convertTripleQuotes(lines)
+	require.Len(t, converted, 4)
+	assert.Equal(t, `{ "source": "\n  a \"b\"\n", "lang": "painless"`, converted[0]+converted[1]+converted[2])
+}
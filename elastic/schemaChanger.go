@@ -2,16 +2,17 @@ package elastic
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	neturl "net/url"
 	"os"
-	"strings"
 	"time"
 )
 
@@ -35,7 +36,31 @@ func (c Creds) AuthorizationNeeded() bool {
 // to backend storage systems
 type SchemaChanger interface {
 	WasApplied(id string) (bool, error)
-	Apply(s *SchemaChange) error
+	GetVersionInfo(id string) (*VersionInfo, error)
+	Apply(s *SchemaChange) ([]StepResult, error)
+	MarkApplied(s *SchemaChange) error
+	Exists(kind, name string) (bool, error)
+	Version() (string, error)
+	Rename(oldID string, sc *SchemaChange) error
+	Delete(id string) error
+}
+
+// StepResult is the outcome of applying one Action of a schema change
+type StepResult struct {
+	Action  Action
+	Status  int //HTTP status returned, 0 when there was no response
+	Err     error
+	Skipped bool //Applied by an earlier deployment that failed at a later step
+}
+
+func (r StepResult) String() string {
+	if r.Skipped {
+		return fmt.Sprintf("%s %s: skipped, applied before", r.Action.HTTPVerb, r.Action.URL)
+	}
+	if r.Err != nil {
+		return fmt.Sprintf("%s %s: %d %v", r.Action.HTTPVerb, r.Action.URL, r.Status, r.Err)
+	}
+	return fmt.Sprintf("%s %s: %d", r.Action.HTTPVerb, r.Action.URL, r.Status)
 }
 
 // EsSchemaChanger handles applying schema changes for Elastic Search
@@ -43,61 +68,164 @@ type EsSchemaChanger struct {
 	ServerURL  string
 	HTTPClient *http.Client
 	Creds      Creds
+	Compress   bool   //gzip request bodies
+	version    string //cached cluster version
 }
 
-// NewEsSchemaChanger creates Elastic Search Schema changer
-func NewEsSchemaChanger(serverURL string, creds Creds, allowInsecure bool) *EsSchemaChanger {
-	if !strings.HasSuffix(serverURL, "/") {
-		serverURL += "/"
-	}
-
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: allowInsecure},
-	}
-
+// NewEsSchemaChanger creates Elastic Search Schema changer and ensures
+// the index used to track applied changes exists
+func NewEsSchemaChanger(serverURL string, creds Creds, allowInsecure bool) (*EsSchemaChanger, error) {
 	sc := &EsSchemaChanger{
-		HTTPClient: &http.Client{Transport: tr},
-		ServerURL:  serverURL,
+		HTTPClient: newHTTPClient(allowInsecure),
+		ServerURL:  normalizeServerURL(serverURL),
 		Creds:      creds,
 	}
-	sc.initialize()
-	return sc
+	if err := sc.initialize(); err != nil {
+		return nil, err
+	}
+	return sc, nil
 }
 
-// WasApplied determins if the schema change has already been applied or not
+// WasApplied determins if the schema change has already been applied or not.
+// A schema change that failed part way through is not applied
 func (s *EsSchemaChanger) WasApplied(id string) (bool, error) {
-	url := fmt.Sprintf("%s%s/%s/%s", s.ServerURL, index, esType, id)
-	req, _ := http.NewRequest("HEAD", url, nil)
+	info, err := s.GetVersionInfo(id)
+	if err != nil || info == nil {
+		return false, err
+	}
+	return !info.Partial, nil
+}
 
+// GetVersionInfo returns the record of an applied schema change or nil
+// if it was never applied
+func (s *EsSchemaChanger) GetVersionInfo(id string) (*VersionInfo, error) {
+	url := s.versionURL(id)
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Add("Accept", "application/json")
 	if s.Creds.AuthorizationNeeded() {
 		req.SetBasicAuth(s.Creds.Username, s.Creds.Password)
 	}
 
 	resp, err := s.HTTPClient.Do(req)
 	if err != nil {
-		return false, err
-	}
-	if resp.StatusCode == 200 {
-		return true, nil
+		return nil, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode == 404 {
-		return false, nil
+		return nil, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, errors.New(resp.Status)
 	}
 
-	return false, errors.New(resp.Status)
+	var doc struct {
+		Source VersionInfo `json:"_source"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return &doc.Source, nil
 }
 
-// Apply will apply the schema change to Elastic Search
-func (s *EsSchemaChanger) Apply(sc *SchemaChange) error {
+// Apply will apply each Action of the schema change to Elastic Search in
+// order and stops at the first failure. The schema change is only tracked
+// as applied when every step succeeds, until then the steps that succeeded
+// are recorded as a partial change so the next deployment resumes at the
+// step that failed. Steps are only skipped while they are unchanged, and
+// always scripts apply every step. Returns the result of each step attempted
+func (s *EsSchemaChanger) Apply(sc *SchemaChange) ([]StepResult, error) {
+	var results []StepResult
+	var applied []string
+	if sc.RunMode != RunAlways && len(sc.Actions) > 1 {
+		info, err := s.GetVersionInfo(sc.ID)
+		if err != nil {
+			return results, err
+		}
+		if info != nil && info.Partial {
+			applied = info.Steps
+		}
+	}
+
+	var steps []string
+	for i, a := range sc.Actions {
+		hash, err := stepHash(a)
+		if err != nil {
+			results = append(results, StepResult{Action: a, Err: err})
+			return results, err
+		}
+		if len(steps) == i && i < len(applied) && applied[i] == hash {
+			steps = append(steps, hash)
+			results = append(results, StepResult{Action: a, Skipped: true})
+			continue
+		}
 
-	url := fmt.Sprintf("%s%s", s.ServerURL, sc.Action.URL)
-	var body io.Reader
-	if sc.Action.JSON != "" {
-		body = bytes.NewBuffer([]byte(sc.Action.JSON))
+		status := 0
+		err = retry(a.Retrys, time.Second, func() error {
+			var err error
+			status, err = s.send(a, sc.Header)
+			return err
+		})
+		results = append(results, StepResult{Action: a, Status: status, Err: err})
+		if err != nil {
+			return results, err
+		}
+		steps = append(steps, hash)
+		if sc.RunMode != RunAlways && i < len(sc.Actions)-1 {
+			//recorded as each step succeeds so a failure, or being stopped, can be resumed
+			v := s.versionInfo(sc)
+			v.Partial, v.Steps = true, steps
+			if err := s.putVersionInfo(v); err != nil {
+				return results, err
+			}
+		}
 	}
-	req, err := http.NewRequest(sc.Action.HTTPVerb, url, body)
+
+	// successfully applied schema so now track its completed
+	return results, s.markScheamaChangeComplete(sc)
+}
+
+// stepHash is the sha256 of the request a step sends, to tell if a step
+// applied by an earlier deployment has changed since
+func stepHash(a Action) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", a.HTTPVerb, a.URL)
+	body, err := a.body()
 	if err != nil {
-		return err
+		return "", err
+	}
+	if body != nil {
+		defer body.Close()
+		if _, err := io.Copy(hash, body); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// send makes a single attempt at applying an Action and returns the HTTP status.
+// A new request is built each attempt so the body can be sent again
+func (s *EsSchemaChanger) send(a Action, h *Header) (int, error) {
+	url := fmt.Sprintf("%s%s", s.ServerURL, a.URL)
+	body, err := a.body()
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		defer body.Close()
+		if s.Compress {
+			body = gzipBody(body)
+		}
+	}
+
+	ctx := context.Background()
+	if h != nil && h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, a.HTTPVerb, url, body)
+	if err != nil {
+		return 0, err
 	}
 	req.Header.Add("Accept", "application/json")
 
@@ -106,42 +234,175 @@ func (s *EsSchemaChanger) Apply(sc *SchemaChange) error {
 	}
 
 	if body != nil {
-		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Content-Type", a.ContentType())
+		if s.Compress {
+			req.Header.Add("Content-Encoding", "gzip")
+		}
+	}
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if a.Assert == nil && !h.expects(resp.StatusCode) {
+		return resp.StatusCode, ErrSchemaChange{
+			Message: string(bodyBytes),
+		}
+	}
+	//items of a _bulk or _msearch can fail even when an assertion holds
+	if a.IsNDJSON() {
+		if err := checkNDJSONResponse(bodyBytes); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+	if a.Assert != nil {
+		return resp.StatusCode, a.Assert.Check(resp.StatusCode, bodyBytes)
+	}
+	return resp.StatusCode, nil
+}
+
+// MarkApplied records the schema change as applied without running it
+func (s *EsSchemaChanger) MarkApplied(sc *SchemaChange) error {
+	return s.markScheamaChangeComplete(sc)
+}
+
+// Exists determines if an index, alias or template exists in Elastic Search
+func (s *EsSchemaChanger) Exists(kind, name string) (bool, error) {
+	var paths []string
+	switch kind {
+	case "index":
+		paths = []string{name}
+	case "alias":
+		paths = []string{"_alias/" + name}
+	case "template":
+		//composable templates first then fall back to legacy templates
+		paths = []string{"_index_template/" + name, "_template/" + name}
+	default:
+		return false, fmt.Errorf("unknown resource kind %s", kind)
 	}
 
-	err = retry(sc.Retrys, time.Second, func() error {
+	for _, p := range paths {
+		req, _ := http.NewRequest("HEAD", s.ServerURL+p, nil)
+		if s.Creds.AuthorizationNeeded() {
+			req.SetBasicAuth(s.Creds.Username, s.Creds.Password)
+		}
 		resp, err := s.HTTPClient.Do(req)
-		if err != nil || resp.StatusCode != 200 {
-			bodyBytes, err2 := ioutil.ReadAll(resp.Body)
-			if err2 != nil {
-				return err2
-			}
-			bodyString := string(bodyBytes)
-			return ErrSchemaChange{
-				Message: bodyString,
-			}
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case 200:
+			return true, nil
+		case 404, 405:
+		default:
+			return false, errors.New(resp.Status)
 		}
-		defer resp.Body.Close()
+	}
+	return false, nil
+}
+
+// Version returns the version number of the Elastic Search cluster
+func (s *EsSchemaChanger) Version() (string, error) {
+	if s.version != "" {
+		return s.version, nil
+	}
+	req, _ := http.NewRequest("GET", s.ServerURL, nil)
+	req.Header.Add("Accept", "application/json")
+	if s.Creds.AuthorizationNeeded() {
+		req.SetBasicAuth(s.Creds.Username, s.Creds.Password)
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", errors.New(resp.Status)
+	}
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", err
+	}
+	s.version = info.Version.Number
+	return s.version, nil
+}
+
+// Rename moves the record of a schema change applied under oldID to the
+// ID, folder and file of sc. Nothing is done when oldID was never applied
+func (s *EsSchemaChanger) Rename(oldID string, sc *SchemaChange) error {
+	info, err := s.GetVersionInfo(oldID)
+	if err != nil || info == nil {
 		return err
-	})
+	}
+	info.ID, info.Folder, info.File = sc.ID, sc.Folder, sc.FileName
+	if err := s.putVersionInfo(info); err != nil {
+		return err
+	}
+	return s.Delete(oldID)
+}
+
+// Delete removes the record of a schema change, which does not have to exist
+func (s *EsSchemaChanger) Delete(id string) error {
+	req, _ := http.NewRequest("DELETE", s.versionURL(id), nil)
+	if s.Creds.AuthorizationNeeded() {
+		req.SetBasicAuth(s.Creds.Username, s.Creds.Password)
+	}
+	resp, err := s.HTTPClient.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 && resp.StatusCode != 404 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
 
-	// successfully applied schema so now track its completed
-	return s.markScheamaChangeComplete(sc)
+// versionURL is the url of the record of a schema change. IDs are paths
+// so they are escaped
+func (s *EsSchemaChanger) versionURL(id string) string {
+	return fmt.Sprintf("%s%s/%s/%s", s.ServerURL, index, esType, neturl.PathEscape(id))
 }
 
 func (s *EsSchemaChanger) markScheamaChangeComplete(sc *SchemaChange) error {
-	url := fmt.Sprintf("%s%s/%s/%s", s.ServerURL, index, esType, sc.ID)
+	return s.putVersionInfo(s.versionInfo(sc))
+}
+
+// versionInfo builds the record of a schema change
+func (s *EsSchemaChanger) versionInfo(sc *SchemaChange) *VersionInfo {
 	h, _ := os.Hostname()
-	v := VersionInfo{
+	v := &VersionInfo{
 		ID:         sc.ID,
 		Folder:     sc.Folder,
 		File:       sc.FileName,
 		Machine:    h,
 		DateRunUtc: time.Now().UTC(),
+		RunMode:    sc.RunMode,
+		Hash:       sc.Hash,
 	}
+	if sc.Header != nil {
+		v.Description = sc.Header.Description
+		v.Author = sc.Header.Author
+		v.Ticket = sc.Header.Ticket
+		v.Tags = sc.Header.Tags
+		v.DependsOn = sc.Header.DependsOn
+	}
+	return v
+}
+
+// putVersionInfo writes the record of a schema change
+func (s *EsSchemaChanger) putVersionInfo(v *VersionInfo) error {
+	url := s.versionURL(v.ID)
 	json, _ := json.Marshal(v)
 	body := bytes.NewBuffer(json)
 	req, _ := http.NewRequest("POST", url, body)
@@ -153,7 +414,9 @@ func (s *EsSchemaChanger) markScheamaChangeComplete(sc *SchemaChange) error {
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != 201 {
+	defer resp.Body.Close()
+	//201 when first recorded, 200 when a repeatable schema change is recorded again
+	if resp.StatusCode != 201 && resp.StatusCode != 200 {
 		b, err1 := ioutil.ReadAll(resp.Body)
 		if err1 != nil {
 			return err1
@@ -166,23 +429,46 @@ func (s *EsSchemaChanger) markScheamaChangeComplete(sc *SchemaChange) error {
 	return nil
 }
 
-func (s *EsSchemaChanger) initialize() {
+// initialize creates the index tracking applied schema changes, with its
+// mapping, when it does not exist
+func (s *EsSchemaChanger) initialize() error {
 	url := fmt.Sprintf("%s%s/%s", s.ServerURL, index, esType)
 	req, _ := http.NewRequest("HEAD", url, nil)
+	if s.Creds.AuthorizationNeeded() {
+		req.SetBasicAuth(s.Creds.Username, s.Creds.Password)
+	}
 	resp, err := s.HTTPClient.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	if resp.StatusCode == 404 {
-		body := bytes.NewBuffer([]byte(typeDefinition))
-		req, _ = http.NewRequest("PUT", url, body)
-		req.Header.Add("Content-Type", "application/json")
-		resp, err = s.HTTPClient.Do(req)
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 404:
+	default:
+		return ErrSchemaChange{Message: fmt.Sprintf("checking %s/%s exists: %s", index, esType, resp.Status)}
+	}
+
+	body := bytes.NewBuffer([]byte(typeDefinition))
+	req, _ = http.NewRequest("PUT", url, body)
+	req.Header.Add("Content-Type", "application/json")
+	if s.Creds.AuthorizationNeeded() {
+		req.SetBasicAuth(s.Creds.Username, s.Creds.Password)
+	}
+	resp, err = s.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+		return ErrSchemaChange{Message: fmt.Sprintf("creating %s/%s: %s", index, esType, b)}
 	}
-
+	return nil
 }
 
 func retry(attempts int, sleep time.Duration, fn func() error) error {
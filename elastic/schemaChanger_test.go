@@ -0,0 +1,169 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingServer is a fake elastic search that records each request
+// and returns the status configured for its path. Version records are
+// kept so they can be read back
+func recordingServer(statuses map[string]int) (*httptest.Server, *[]string) {
+	var requests []string
+	records := make(map[string]string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		if strings.HasPrefix(r.URL.Path, "/esdeploy_v1/version_info/") {
+			switch r.Method {
+			case "GET":
+				if record, ok := records[r.URL.Path]; ok {
+					w.Write([]byte(`{"_source":` + record + `}`))
+				} else {
+					w.WriteHeader(404)
+				}
+			case "POST":
+				records[r.URL.Path] = string(body)
+				w.WriteHeader(201)
+			}
+			return
+		}
+		status, ok := statuses[r.URL.Path]
+		if !ok {
+			status = 200
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"acknowledged":true}`))
+	}))
+	return ts, &requests
+}
+
+func TestNewEsSchemaChangerCreatesTrackingIndex(t *testing.T) {
+	var requests []string
+	exists := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "elastic" || pass != "secret" {
+			w.WriteHeader(401)
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == "HEAD" && !exists:
+			w.WriteHeader(404)
+		case r.Method == "PUT":
+			exists = true
+		}
+	}))
+	defer ts.Close()
+
+	_, err := NewEsSchemaChanger(ts.URL, Creds{}, false)
+	assert.EqualError(t, err, "checking esdeploy_v1/version_info exists: 401 Unauthorized")
+
+	_, err = NewEsSchemaChanger(ts.URL, Creds{Username: "elastic", Password: "secret"}, false)
+	require.NoError(t, err)
+	_, err = NewEsSchemaChanger(ts.URL, Creds{Username: "elastic", Password: "secret"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"HEAD /esdeploy_v1/version_info", "PUT /esdeploy_v1/version_info", "HEAD /esdeploy_v1/version_info"}, requests)
+}
+
+func TestApplyMultipleSteps(t *testing.T) {
+	ts, requests := recordingServer(nil)
+	defer ts.Close()
+
+	sc, err := NewSchemaChange("../tests", "../tests/multiple_requests.js", 1, 0)
+	require.NoError(t, err)
+	changer, err := NewEsSchemaChanger(ts.URL, Creds{}, false)
+	require.NoError(t, err)
+
+	steps, err := changer.Apply(sc)
+	require.NoError(t, err)
+	require.Len(t, steps, 3)
+	assert.Equal(t, "POST _aliases: 200", steps[1].String())
+
+	//initialize, the record, the 3 steps with the progress after the first two and then the version info
+	require.Len(t, *requests, 8)
+	assert.Contains(t, (*requests)[1], "GET /esdeploy_v1/version_info/multiple_requests.js")
+	assert.Contains(t, (*requests)[2], "PUT /cars_v1")
+	assert.Contains(t, (*requests)[3], `"partial":true`)
+	assert.Contains(t, (*requests)[4], "POST /_aliases")
+	assert.Contains(t, (*requests)[5], `"partial":true`)
+	assert.Contains(t, (*requests)[6], "PUT /_ingest/pipeline/cars")
+	assert.Contains(t, (*requests)[7], "POST /esdeploy_v1/version_info/multiple_requests.js")
+	assert.NotContains(t, (*requests)[7], `"partial"`)
+	applied, err := changer.WasApplied(sc.ID)
+	require.NoError(t, err)
+	assert.True(t, applied)
+}
+
+func TestApplyStopsAtFailedStep(t *testing.T) {
+	statuses := map[string]int{"/_aliases": 400}
+	ts, requests := recordingServer(statuses)
+	defer ts.Close()
+
+	sc, err := NewSchemaChange("../tests", "../tests/multiple_requests.js", 1, 0)
+	require.NoError(t, err)
+	changer, err := NewEsSchemaChanger(ts.URL, Creds{}, false)
+	require.NoError(t, err)
+
+	steps, err := changer.Apply(sc)
+	assert.IsType(t, ErrAssertion{}, err)
+	require.Len(t, steps, 2)
+	assert.Equal(t, 400, steps[1].Status)
+	//nothing sent after the failed step and it is not tracked as applied
+	require.Len(t, *requests, 5)
+	assert.Contains(t, (*requests)[4], "POST /_aliases")
+	applied, err := changer.WasApplied(sc.ID)
+	require.NoError(t, err)
+	assert.False(t, applied)
+
+	//the next deployment resumes at the failed step
+	delete(statuses, "/_aliases")
+	*requests = nil
+	steps, err = changer.Apply(sc)
+	require.NoError(t, err)
+	require.Len(t, steps, 3)
+	assert.Equal(t, "PUT cars_v1: skipped, applied before", steps[0].String())
+	assert.Contains(t, (*requests)[1], "POST /_aliases")
+	assert.NotContains(t, strings.Join(*requests, "\n"), "PUT /cars_v1")
+	applied, err = changer.WasApplied(sc.ID)
+	require.NoError(t, err)
+	assert.True(t, applied)
+
+	//a changed step is applied again along with the steps after it
+	*requests = nil
+	sc.RunMode = RunOnChange
+	sc.Actions[0].bodyFile, sc.Actions[0].JSON = "", `{"settings":{}}`
+	require.NoError(t, changer.putVersionInfo(&VersionInfo{ID: sc.ID, Partial: true, Steps: []string{"changed"}}))
+	_, err = changer.Apply(sc)
+	require.NoError(t, err)
+	assert.Contains(t, (*requests)[2], "PUT /cars_v1")
+}
+
+func TestRenameEscapesIDs(t *testing.T) {
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+		switch r.Method {
+		case "GET":
+			w.Write([]byte(`{"_source":{"id":"common-01.js","folder":"common","file":"01.js"}}`))
+		case "POST":
+			w.WriteHeader(201)
+		}
+	}))
+	defer ts.Close()
+
+	changer := &EsSchemaChanger{ServerURL: ts.URL + "/", HTTPClient: ts.Client()}
+	err := changer.Rename("common-01.js", &SchemaChange{ID: "a/common/01.js", Folder: "a/common", FileName: "01.js"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET /esdeploy_v1/version_info/common-01.js",
+		"POST /esdeploy_v1/version_info/a%2Fcommon%2F01.js",
+		"DELETE /esdeploy_v1/version_info/common-01.js",
+	}, requests)
+}
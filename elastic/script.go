@@ -0,0 +1,235 @@
+package elastic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// consoleExt is the extension of Kibana Dev Tools console files
+const consoleExt = ".http"
+
+// scriptExtensions are the schema file extensions picked up by the Runner
+var scriptExtensions = append([]string{".js", consoleExt}, yamlExtensions...)
+
+// isScriptFile determines if path is a schema file based on its extension
+func isScriptFile(path string) bool {
+	ext := filepath.Ext(path)
+	for _, e := range scriptExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// script is the raw content of a schema file split into its parts, along
+// with the line number each part was read from
+type script struct {
+	Header     []string //Optional comment lines before the first verb
+	HeaderLine int
+	Steps      []*scriptStep
+	When       []string //Optional precondition block following the WHEN line
+	WhenLine   int
+	Hash       string //sha256 of the raw file content
+}
+
+// scriptStep is a single request within a schema file
+type scriptStep struct {
+	Verb       string
+	VerbLine   int
+	URL        string
+	URLLine    int
+	Body       []string
+	BodyLine   int
+	Assert     []string //Optional assertion block following the ASSERT line
+	AssertLine int
+	File       string //Set when the body is streamed from the file instead of read into Body
+	BodyStart  int64  //Where the body starts in File
+	BodyEnd    int64  //Where the body ends in File
+}
+
+// bodyLines returns the lines of the body, read from the file when it is
+// streamed
+func (step *scriptStep) bodyLines() ([]string, error) {
+	if step.File == "" {
+		return step.Body, nil
+	}
+	file, err := os.Open(step.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(step.BodyStart, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var lines []string
+	scanner := newLineScanner(io.LimitReader(file, step.BodyEnd-step.BodyStart))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// readScript will read the optional header comments followed by one or
+// more requests from a schema file. Each request is a verb, the url on
+// the next line and the body. The verb and url can also be on the same
+// line like the Kibana Dev Tools console (PUT /cars). A body ends at the
+// next request or an ASSERT or WHEN line which is followed by the
+// assertion or precondition block.
+//
+// The bodies of .js files are not read into memory, only where each starts
+// and ends so they can be streamed from the file when sent. Console files
+// (.http) can also have # comments anywhere and use triple quoted strings
+// which are converted to JSON strings
+func readScript(esFile string) (*script, error) {
+	if isYAMLFile(esFile) {
+		return readYAMLScript(esFile)
+	}
+	console := filepath.Ext(esFile) == consoleExt
+	file, err := os.Open(esFile)
+	if err != nil {
+		return nil, ErrScriptFile{File: esFile, Err: err}
+	}
+	defer file.Close()
+
+	sc := &script{HeaderLine: 1}
+	hash := sha256.New()
+	scanner := newLineScanner(io.TeeReader(file, hash))
+	var step *scriptStep
+	var section *[]string
+	needURL := false
+	inTripleQuote := false
+	line := 0
+	var offset int64 //where the current line starts
+	endBody := func() {
+		if !console && step != nil && section == &step.Body {
+			step.BodyEnd = offset
+		}
+	}
+	for ; scanner.Scan(); offset = scanner.read {
+		line++
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		switch {
+		case inTripleQuote:
+			*section = append(*section, text)
+		case needURL:
+			step.URL, step.URLLine, step.BodyLine = text, line, line+1
+			step.BodyStart = scanner.read
+			needURL = false
+		case step == nil && (trimmed == "" || isComment(trimmed, console)):
+			sc.Header = append(sc.Header, text)
+		case console && isComment(trimmed, console):
+			//keep an empty line so the body line numbers still match the file
+			*section = append(*section, "")
+		case step != nil && trimmed == assertKeyword:
+			endBody()
+			section, step.AssertLine = &step.Assert, line+1
+		case trimmed == whenKeyword:
+			endBody()
+			section, sc.WhenLine = &sc.When, line+1
+		case step == nil || isStepStart(text):
+			//the first line after the header is always a request even when the verb is invalid
+			endBody()
+			step = &scriptStep{VerbLine: line}
+			if !console {
+				step.File = esFile
+			}
+			sc.Steps = append(sc.Steps, step)
+			section = &step.Body
+			if verb, url, ok := splitRequestLine(text); ok {
+				step.Verb, step.URL, step.URLLine, step.BodyLine = verb, url, line, line+1
+				step.BodyStart = scanner.read
+			} else {
+				step.Verb = text
+				step.URLLine, step.BodyLine = line+1, line+2
+				needURL = true
+			}
+		case !console && section == &step.Body:
+			//streamed from the file when sent
+		default:
+			*section = append(*section, text)
+		}
+		if console && strings.Count(text, tripleQuote)%2 == 1 {
+			inTripleQuote = !inTripleQuote
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, ErrScriptFile{File: esFile, Err: err}
+	}
+	endBody()
+	if console {
+		for _, step := range sc.Steps {
+			step.Body = convertTripleQuotes(step.Body)
+		}
+	}
+	sc.Hash = hex.EncodeToString(hash.Sum(nil))
+	return sc, nil
+}
+
+// isComment determines if a trimmed line is a comment. Console files
+// allow # comments as well as //
+func isComment(trimmed string, console bool) bool {
+	return strings.HasPrefix(trimmed, headerPrefix) || (console && strings.HasPrefix(trimmed, "#"))
+}
+
+// tripleQuote starts and ends a multi-line string in console files
+const tripleQuote = `"""`
+
+// convertTripleQuotes replaces console triple quoted strings with JSON
+// strings. The number of lines is unchanged so line numbers still match
+// the file, the lines used by a string are left empty after it
+func convertTripleQuotes(lines []string) []string {
+	text := strings.Join(lines, "\n")
+	if !strings.Contains(text, tripleQuote) {
+		return lines
+	}
+
+	var out strings.Builder
+	for {
+		start := strings.Index(text, tripleQuote)
+		if start == -1 {
+			break
+		}
+		end := strings.Index(text[start+3:], tripleQuote)
+		if end == -1 {
+			break //unterminated, left as is so validation reports it
+		}
+		value := text[start+3 : start+3+end]
+		quoted, _ := json.Marshal(value)
+		out.WriteString(text[:start])
+		out.Write(quoted)
+		out.WriteString(strings.Repeat("\n", strings.Count(value, "\n")))
+		text = text[start+3+end+3:]
+	}
+	out.WriteString(text)
+	return strings.Split(out.String(), "\n")
+}
+
+// isStepStart determines if a line starts a new request, either a verb on
+// its own or a verb followed by the url. Keywords are not requests
+func isStepStart(text string) bool {
+	if text == assertKeyword || text == whenKeyword {
+		return false
+	}
+	if isVerb(text) {
+		return true
+	}
+	_, _, ok := splitRequestLine(text)
+	return ok
+}
+
+// splitRequestLine splits a console style request line (PUT /cars) into
+// the verb and url
+func splitRequestLine(text string) (string, string, bool) {
+	parts := strings.Fields(text)
+	if len(parts) != 2 || !isVerb(parts[0]) || parts[0] == assertKeyword || parts[0] == whenKeyword {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,191 @@
+package elastic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// bulkBatch collects seed documents for a single _bulk request
+type bulkBatch struct {
+	items []seedItem
+	docs  [][]byte //The action and document lines of each item
+	size  int
+}
+
+// full determines if a document of size bytes no longer fits in the batch
+func (b *bulkBatch) full(s *Seeder, size int) bool {
+	if len(b.items) == 0 {
+		return false
+	}
+	return len(b.items) >= s.BatchSize || (s.BatchBytes > 0 && b.size+size > s.BatchBytes)
+}
+
+func (b *bulkBatch) add(item seedItem, doc []byte) {
+	b.items = append(b.items, item)
+	b.docs = append(b.docs, doc)
+	b.size += len(doc)
+}
+
+// bulkItem converts a seed file to its action and document lines of a _bulk
+// request. Returns false when the file has to be sent on its own, either
+// its url is not a document (cars/_doc/1) or it is larger than BatchBytes
+func (s *Seeder) bulkItem(a Action) ([]byte, bool, error) {
+	meta, ok := bulkMeta(a)
+	if !ok {
+		return nil, false, nil
+	}
+	body, err := a.body()
+	if err != nil || body == nil {
+		return nil, false, err
+	}
+	defer body.Close()
+
+	var doc []byte
+	if s.BatchBytes > 0 {
+		//stop reading once the document is too big for a batch
+		doc, err = ioutil.ReadAll(io.LimitReader(body, int64(s.BatchBytes)+1))
+		if len(doc) > s.BatchBytes {
+			return nil, false, nil
+		}
+	} else {
+		doc, err = ioutil.ReadAll(body)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	//each document has to be on a single line
+	var line bytes.Buffer
+	line.Write(meta)
+	line.WriteString("\n")
+	if err := json.Compact(&line, doc); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrBadJSON, err)
+	}
+	line.WriteString("\n")
+	return line.Bytes(), true, nil
+}
+
+// bulkMeta builds the action line of a _bulk request for a seed url made up
+// of the index, type and id (cars/_doc/1), a POST to index/_doc for a
+// document without an ID or the create and update forms of them. Urls with
+// any other query string or API are not sent with _bulk
+func bulkMeta(a Action) ([]byte, bool) {
+	url, action := a.URL, "index"
+	if strings.HasSuffix(url, "?op_type=create") {
+		url, action = strings.TrimSuffix(url, "?op_type=create"), "create"
+	}
+	if strings.Contains(url, "?") {
+		return nil, false
+	}
+	parts := strings.Split(strings.Trim(url, "/"), "/")
+	var index, docType, id string
+	switch {
+	case a.HTTPVerb == "POST" && len(parts) == 2 && parts[1] == "_doc":
+		index = parts[0]
+	case a.HTTPVerb == "POST" && len(parts) == 3 && parts[1] == "_update":
+		index, id, action = parts[0], parts[2], "update"
+	case a.HTTPVerb == "POST" && len(parts) == 4 && parts[3] == "_update":
+		index, docType, id, action = parts[0], parts[1], parts[2], "update"
+	case a.HTTPVerb == "PUT" && len(parts) == 3 && parts[1] == "_create":
+		index, id, action = parts[0], parts[2], "create"
+	case a.HTTPVerb == "PUT" && len(parts) == 3:
+		index, docType, id = parts[0], parts[1], parts[2]
+	default:
+		return nil, false
+	}
+	if strings.HasPrefix(index, "_") || strings.HasPrefix(id, "_") ||
+		(strings.HasPrefix(docType, "_") && docType != "_doc") {
+		return nil, false
+	}
+	meta := map[string]string{"_index": index}
+	if id != "" {
+		unescaped, err := neturl.PathUnescape(id)
+		if err != nil {
+			return nil, false
+		}
+		meta["_id"] = unescaped
+	}
+	if docType != "" && docType != "_doc" {
+		meta["_type"] = docType //elastic search 6 and earlier
+	}
+	b, _ := json.Marshal(map[string]interface{}{action: meta})
+	return b, true
+}
+
+// flush passes the batch on to a worker and starts a new one. The caller
+// holds batchMu
+func (r *seedRun) flush() {
+	b := r.batch
+	r.batch = new(bulkBatch)
+	if len(b.items) == 0 {
+		return
+	}
+	r.sends.run(func() { r.sendBulk(b) })
+}
+
+// sendBulk sends the batch as a _bulk request and finishes each file with
+// the result of its own document. Documents rejected with 429, because the
+// cluster is too busy, are sent again in a smaller batch after a backoff
+func (r *seedRun) sendBulk(b *bulkBatch) {
+	s := r.seeder
+	wait := s.Backoff
+	for attempt := 1; ; attempt++ {
+		resp, err := s.execute(Action{HTTPVerb: "POST", URL: "_bulk", JSON: string(bytes.Join(b.docs, nil))}, int64(b.size))
+		var results []ndjsonItemResult
+		if err == nil {
+			results, err = bulkItemResults(resp, len(b.items))
+		}
+
+		rejected := new(bulkBatch)
+		for i, item := range b.items {
+			if err != nil {
+				r.finish(item, false, err)
+				continue
+			}
+			result := results[i]
+			if result.Status == http.StatusTooManyRequests && attempt < s.Retries {
+				rejected.add(item, b.docs[i])
+				continue
+			}
+			var itemErr error
+			if result.Error != nil || !seedSuccess(item.action, result.Status) {
+				itemErr = ErrSchemaChange{Message: fmt.Sprintf("%d %s", result.Status, result.Error)}
+			}
+			r.finish(item, false, itemErr)
+		}
+		if len(rejected.items) == 0 {
+			r.flushRecords()
+			return
+		}
+		b = rejected
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// bulkItemResults reads the result of each document from a _bulk response
+func bulkItemResults(resp []byte, count int) ([]ndjsonItemResult, error) {
+	var result struct {
+		Items []map[string]ndjsonItemResult `json:"items"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Items) != count {
+		return nil, fmt.Errorf("expected %d items in the _bulk response but got %d", count, len(result.Items))
+	}
+	results := make([]ndjsonItemResult, count)
+	for i, item := range result.Items {
+		for _, r := range item {
+			results[i] = r
+		}
+	}
+	return results, nil
+}
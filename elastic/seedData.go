@@ -0,0 +1,535 @@
+package elastic
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// dataFormats are the extensions of seed files holding many documents
+// rather than a single document with its url on the first line. A .json
+// file is only a data file when a line of the .esdeployseed file matches
+// it, so other JSON in the folder is not seeded
+var dataFormats = map[string]string{
+	".ndjson": "ndjson", //one document per line
+	".jsonl":  "ndjson",
+	".json":   "json", //an array of documents
+	".csv":    "csv",  //a header row with the field names and a document per row
+}
+
+// seedMappingFile sets the index and ID field of data files, one per line
+// with a glob and the index optionally followed by the field holding the
+// ID of each document (cars/*.csv -> cars vin). An op= option sets the
+// operation of the matching seed files (trucks/** -> op=upsert). It is read
+// from the root of the seed folder, lines starting with # are comments
+const seedMappingFile = ".esdeployseed"
+
+// seedMapping is a line of the seed mapping file
+type seedMapping struct {
+	pattern string
+	index   string
+	idField string
+	op      string
+}
+
+// readSeedMappings reads the seed mapping file of dir
+func readSeedMappings(dir string) ([]seedMapping, error) {
+	path := filepath.Join(dir, seedMappingFile)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mappings []seedMapping
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.Split(text, "->")
+		var fields, target []string
+		if len(parts) == 2 {
+			fields = strings.Fields(parts[1])
+		}
+		m := seedMapping{pattern: strings.TrimSpace(parts[0])}
+		for _, f := range fields {
+			if strings.HasPrefix(f, "op=") {
+				m.op = strings.TrimPrefix(f, "op=")
+			} else {
+				target = append(target, f)
+			}
+		}
+		if len(fields) < 1 || len(target) > 2 || m.pattern == "" {
+			return nil, ErrScriptFile{File: path, Line: line, Err: fmt.Errorf("expecting glob -> index [id field] [op=operation] but was %q", text)}
+		}
+		if err := validSeedOp(m.op); err != nil {
+			return nil, ErrScriptFile{File: path, Line: line, Err: err}
+		}
+		if len(target) > 0 {
+			m.index = target[0]
+		}
+		if len(target) > 1 {
+			m.idField = target[1]
+		}
+		mappings = append(mappings, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, ErrScriptFile{File: path, Err: err}
+	}
+	return mappings, nil
+}
+
+func (r *seedRun) isDataFile(file string) bool {
+	format, ok := dataFormats[strings.ToLower(filepath.Ext(file))]
+	if format == "json" {
+		return findSeedMapping(r.relPath(file), r.mappings).pattern != ""
+	}
+	return ok
+}
+
+// dataFile is a seed file holding many documents. It is finished once
+// every document has been sent
+type dataFile struct {
+	rel     string //Path relative to the seed folder, the generated IDs start with it
+	index   string
+	idField string
+	op      string
+	info    *SeedInfo //Record of the file, written once every document is seeded
+	mu      sync.Mutex
+	pending int //documents not finished yet, plus one while the file is read
+	total   int
+	failed  [][]byte //documents that failed, to move to the poison folder
+	first   error
+}
+
+// dataReader reads the documents of a data file. A header of comment lines
+// (// or #) at the top of the file sets its index, ID field and operation
+//
+//	// index: cars
+//	// id: vin
+//	// op: create
+type dataReader struct {
+	file   *os.File
+	header map[string]string
+	next   func() ([]byte, error) //returns io.EOF after the last document
+	count  int
+	lines  int //lines of the header
+	line   int //line the last document starts on
+}
+
+func openDataFile(path string) (*dataReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &dataReader{file: file, header: make(map[string]string)}
+	br := bufio.NewReader(file)
+	if err := d.readHeader(br); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	switch dataFormats[strings.ToLower(filepath.Ext(path))] {
+	case "ndjson":
+		d.line = d.lines
+		d.next = func() ([]byte, error) {
+			for {
+				line, err := br.ReadBytes('\n')
+				d.line++
+				if len(bytes.TrimSpace(line)) > 0 {
+					return line, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	case "json":
+		newlines := &newlineReader{r: br}
+		dec := json.NewDecoder(newCommentReader(newlines))
+		if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+			file.Close()
+			return nil, errors.New("expecting an array of documents")
+		}
+		d.next = func() ([]byte, error) {
+			if !dec.More() {
+				return nil, io.EOF
+			}
+			var doc json.RawMessage
+			err := dec.Decode(&doc)
+			d.line = d.lines + 1 + newlines.before(dec.InputOffset()-int64(len(doc)))
+			return doc, err
+		}
+	case "csv":
+		r := csv.NewReader(br)
+		fields, err := r.Read()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("expecting a header row: %v", err)
+		}
+		d.line = d.lines + 1
+		d.next = func() ([]byte, error) {
+			d.line++ //a row with a quoted line break takes more than one line
+			row, err := r.Read()
+			if err != nil {
+				return nil, err
+			}
+			doc := make(map[string]string, len(fields))
+			for i, field := range fields {
+				doc[field] = row[i]
+			}
+			return json.Marshal(doc)
+		}
+	}
+	return d, nil
+}
+
+// readHeader reads the comment lines at the top of the file
+func (d *dataReader) readHeader(br *bufio.Reader) error {
+	for {
+		b, _ := br.Peek(2)
+		if !bytes.HasPrefix(b, []byte("#")) && !bytes.HasPrefix(b, []byte(headerPrefix)) {
+			return nil
+		}
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		d.lines++
+		seedHeaderLine(line, d.header)
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Next returns the next document on a single line, or io.EOF
+func (d *dataReader) Next() ([]byte, error) {
+	doc, err := d.next()
+	if err != nil {
+		if err != io.EOF {
+			err = fmt.Errorf("document %d: %v", d.count+1, err)
+		}
+		return nil, err
+	}
+	d.count++
+	var line bytes.Buffer
+	if err := json.Compact(&line, doc); err != nil {
+		return nil, fmt.Errorf("document %d: %w: %v", d.count, ErrBadJSON, err)
+	}
+	return line.Bytes(), nil
+}
+
+// newlineReader records where the lines of what is read end, so the line
+// of an offset can be found
+type newlineReader struct {
+	r        io.Reader
+	read     int64
+	newlines []int64
+}
+
+func (n *newlineReader) Read(p []byte) (int, error) {
+	count, err := n.r.Read(p)
+	for i, c := range p[:count] {
+		if c == '\n' {
+			n.newlines = append(n.newlines, n.read+int64(i))
+		}
+	}
+	n.read += int64(count)
+	return count, err
+}
+
+// before is the number of line breaks before offset
+func (n *newlineReader) before(offset int64) int {
+	return sort.Search(len(n.newlines), func(i int) bool { return n.newlines[i] >= offset })
+}
+
+func (d *dataReader) Close() error {
+	return d.file.Close()
+}
+
+// target determines the index, ID field and operation of a data file from
+// its header, then the seed mapping file and otherwise the index is the
+// file name
+func (d *dataReader) target(rel string, mappings []seedMapping) *dataFile {
+	m := findSeedMapping(rel, mappings)
+	data := &dataFile{rel: rel, index: m.index, idField: m.idField, op: m.op}
+	if data.index == "" {
+		data.index = strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel))
+	}
+	if v, ok := d.header["index"]; ok {
+		data.index = v
+	}
+	if v, ok := d.header["id"]; ok {
+		data.idField = v
+	}
+	if v, ok := d.header["op"]; ok {
+		data.op = v
+	}
+	return data
+}
+
+// findSeedMapping returns the first line of the seed mapping file matching
+// the path relative to the seed folder
+func findSeedMapping(rel string, mappings []seedMapping) seedMapping {
+	for _, m := range mappings {
+		if matchGlob(m.pattern, rel) {
+			return m
+		}
+	}
+	return seedMapping{}
+}
+
+// documentAction is the request that seeds document n of a data file, PUT
+// index/_doc/id changed into the operation of the file. Without an ID field
+// the ID is the path of the file and the number of the document
+// (data/cars.ndjson#3), so seeding the file again replaces the documents
+// rather than adding them twice. An _id field is taken out of the document.
+// Returns the ID of the document
+func (d *dataFile) documentAction(doc []byte, n int) (Action, string, error) {
+	id := d.generatedID(n)
+	if d.idField != "" {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(doc, &fields); err != nil {
+			return Action{}, "", err
+		}
+		raw, ok := fields[d.idField]
+		if !ok {
+			return Action{}, "", fmt.Errorf("no %s field", d.idField)
+		}
+		id = ""
+		switch raw[0] {
+		case '"':
+			json.Unmarshal(raw, &id)
+		case 'n', 't', 'f', '{', '[':
+			return Action{}, "", fmt.Errorf("%s field is %s, not a string or number", d.idField, jsonKind(raw))
+		default:
+			id = string(raw) //a number
+		}
+		if id == "" {
+			return Action{}, "", fmt.Errorf("empty %s field", d.idField)
+		}
+		if d.idField == "_id" {
+			//a metadata field which can not be in the document
+			delete(fields, "_id")
+			doc, _ = json.Marshal(fields)
+		}
+	}
+	a, err := seedOperation(d.op, Action{HTTPVerb: "PUT", URL: d.index + "/_doc/" + neturl.PathEscape(id), JSON: string(doc)})
+	return a, id, err
+}
+
+// jsonKind names the type of a JSON value for error messages
+func jsonKind(raw json.RawMessage) string {
+	switch raw[0] {
+	case 'n':
+		return "null"
+	case 't', 'f':
+		return "a boolean"
+	case '{':
+		return "an object"
+	}
+	return "an array"
+}
+
+// generatedID is the ID of document n of a data file without an ID field
+func (d *dataFile) generatedID(n int) string {
+	return d.rel + "#" + strconv.Itoa(n)
+}
+
+// documentInfo builds the record of a document of a data file. Documents
+// are tracked on their own, as index#id, so when some documents of a file
+// fail or the file changes only the documents that failed or changed are
+// sent again. The hash covers the request so a new index or operation sends
+// the document again
+func (s *Seeder) documentInfo(file *SeedInfo, a Action, index, id string, doc []byte) *SeedInfo {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", a.HTTPVerb, a.URL)
+	hash.Write(doc)
+	info := *file
+	info.ID = index + "#" + id
+	info.URL = a.URL
+	info.Hash = hex.EncodeToString(hash.Sum(nil))
+	info.Size = int64(len(doc))
+	return &info
+}
+
+// checkData determines the index, ID field and operation of a data file,
+// which is then sent unless it is unchanged. Each document goes through the
+// same workers and batches as the other seed files
+func (r *seedRun) checkData(file string) {
+	s := r.seeder
+	item := seedItem{file: file}
+	reader, err := openDataFile(file)
+	if err != nil {
+		r.finish(item, false, ErrScriptFile{File: file, Err: err})
+		return
+	}
+	reader.Close()
+
+	data := reader.target(r.relPath(file), r.mappings)
+	item.data = data
+	if data.index, err = s.template.replace(data.index); err != nil {
+		r.finish(seedItem{file: file}, false, ErrScriptFile{File: file, Err: err})
+		return
+	}
+	if err := validSeedOp(data.op); err != nil {
+		r.finish(seedItem{file: file}, false, ErrScriptFile{File: file, Err: err})
+		return
+	}
+	if (data.op == SeedOpUpdate || data.op == SeedOpUpsert) && data.idField == "" {
+		r.finish(seedItem{file: file}, false, ErrScriptFile{File: file, Err: fmt.Errorf("the %s operation needs an ID field", data.op)})
+		return
+	}
+
+	if item.info, err = s.newSeedInfo(file, data.rel, Action{URL: data.index}); err != nil {
+		r.finish(item, false, err)
+		return
+	}
+	r.lookup(item)
+}
+
+// sendData reads the documents of a data file that is new or changed and
+// sends each of them
+func (r *seedRun) sendData(item seedItem) {
+	s, file, data := r.seeder, item.file, item.data
+	reader, err := openDataFile(file)
+	if err != nil {
+		r.finish(seedItem{file: file}, false, ErrScriptFile{File: file, Err: err})
+		return
+	}
+	defer reader.Close()
+
+	data.pending, data.info = 1, item.info
+	for {
+		doc, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			//the file can not be read any further so all of it is poison
+			data.mu.Lock()
+			data.first, data.failed = ErrScriptFile{File: file, Err: err}, nil
+			data.mu.Unlock()
+			break
+		}
+		data.mu.Lock()
+		data.pending++
+		data.total++
+		data.mu.Unlock()
+		docItem := item
+		docItem.doc, docItem.n = doc, reader.count
+		text, err := s.template.replace(string(doc))
+		var id string
+		if err == nil {
+			docItem.doc = []byte(text)
+			docItem.action, id, err = data.documentAction(docItem.doc, docItem.n)
+		}
+		if err != nil {
+			r.finishDocument(docItem, false, fmt.Errorf("document %d (line %d): %v", reader.count, reader.line, err))
+			continue
+		}
+		docItem.info = s.documentInfo(item.info, docItem.action, data.index, id, docItem.doc)
+		r.lookup(docItem)
+	}
+	r.finishDocument(item, false, nil) //done reading
+}
+
+// finishDocument records the outcome of a document of a data file, which
+// is recorded once it is seeded. The file is finished after its last document
+func (r *seedRun) finishDocument(item seedItem, unchanged bool, err error) {
+	data := item.data
+	data.mu.Lock()
+	if err != nil && item.doc != nil {
+		if data.first == nil {
+			data.first = err
+		}
+		if _, broken := data.first.(ErrScriptFile); !broken {
+			doc := item.doc
+			if data.idField == "" {
+				//the generated ID is kept so the document is not added twice
+				doc = withID(doc, data.generatedID(item.n))
+			}
+			data.failed = append(data.failed, doc)
+		}
+	}
+	data.pending--
+	done := data.pending == 0
+	data.mu.Unlock()
+
+	if item.doc != nil && err == nil && !unchanged {
+		r.mu.Lock()
+		r.report.Documents++
+		r.mu.Unlock()
+		r.queueRecord(item.info)
+	}
+	if !done {
+		return
+	}
+
+	item.doc, item.info = nil, data.info
+	err = data.first
+	if len(data.failed) > 0 {
+		err = fmt.Errorf("%d of %d documents failed, first failure %v", len(data.failed), data.total, data.first)
+	} else if err != nil {
+		item.data = nil //unreadable, the whole file is poison
+	}
+	r.finish(item, false, err)
+}
+
+// withID adds an _id field to the start of a JSON object
+func withID(doc []byte, id string) []byte {
+	if !bytes.HasPrefix(doc, []byte("{")) {
+		return doc
+	}
+	quoted, _ := json.Marshal(id)
+	field := append([]byte(`{"_id":`), quoted...)
+	if rest := bytes.TrimSpace(doc[1:]); !bytes.HasPrefix(rest, []byte("}")) {
+		field = append(field, ',')
+	}
+	return append(field, doc[1:]...)
+}
+
+// poisonDocuments writes the documents of a data file that failed to the
+// poison folder as an NDJSON file, so only they are seeded again. Documents
+// without an ID field are written with their generated ID as _id. Returns
+// the path of the file
+func (r *seedRun) poisonDocuments(item seedItem, dir string) (string, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// index: %s\n", item.data.index)
+	idField := item.data.idField
+	if idField == "" {
+		idField = "_id"
+	}
+	fmt.Fprintf(&b, "// id: %s\n", idField)
+	if item.data.op != "" {
+		fmt.Fprintf(&b, "// op: %s\n", item.data.op)
+	}
+	for _, doc := range item.data.failed {
+		b.Write(doc)
+		b.WriteString("\n")
+	}
+	name := filepath.Join(dir, strings.TrimSuffix(filepath.Base(item.file), filepath.Ext(item.file))+".ndjson")
+	return name, ioutil.WriteFile(name, b.Bytes(), 0666)
+}
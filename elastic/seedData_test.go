@@ -0,0 +1,169 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedDataFiles(t *testing.T) {
+	for _, batch := range []int{1, 10} {
+		ts, records, docs := seedServer(nil)
+		dir, err := ioutil.TempDir("", "esdeploy")
+		require.NoError(t, err)
+
+		writeScript(t, dir, seedMappingFile, "# glob -> index [id field]\ndata/*.csv -> trucks vin\ndata/*.json -> bikes\n")
+		ndjson := writeScript(t, dir, "data/cars.ndjson", "// index: autos\n// id: id\n{\"id\": 1, \"make\": \"Ford\"}\n\n{\"id\": \"a/2\", \"make\": \"Kia\"}\n")
+		csv := writeScript(t, dir, "data/fleet.csv", "vin,make\r\nV1,Volvo\r\nV2,\"Scania, R\"\r\n")
+		array := writeScript(t, dir, "data/bikes.json", "// no id field so the ids are generated\n[\n  { \"make\": \"Trek\" },\n  { \"make\": \"Giant\" }\n]")
+
+		seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+		seeder.Keep = true
+		seeder.BatchSize = batch
+		results, err := seeder.Seed()
+		require.NoError(t, err)
+
+		assert.Contains(t, results, "Success: "+ndjson)
+		assert.Contains(t, results, "Success: "+csv)
+		assert.Contains(t, results, "Success: "+array)
+		assert.True(t, strings.HasPrefix(results[len(results)-1], "Seeded 6 documents"), results[len(results)-1])
+		assert.Equal(t, 1, docs["/autos/_doc/1"])
+		assert.Equal(t, 1, docs["/autos/_doc/a/2"]+docs["/autos/_doc/a%2F2"])
+		assert.Equal(t, 1, docs["/trucks/_doc/V1"])
+		assert.Equal(t, 1, docs["/trucks/_doc/V2"])
+		assert.Equal(t, 1, docs["/bikes/_doc/data/bikes.json#1"])
+		assert.Equal(t, 1, docs["/bikes/_doc/data/bikes.json#2"])
+		assert.Contains(t, records["data/cars.ndjson"], `"url":"autos"`)
+
+		//unchanged files are not read again
+		results, err = seeder.Seed()
+		require.NoError(t, err)
+		assert.Contains(t, results, "Unchanged: "+csv)
+		assert.Equal(t, 1, docs["/trucks/_doc/V1"])
+
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSeedDataFilePoisonsFailedDocuments(t *testing.T) {
+	ts, records, _ := seedServer(map[string]bool{"/cars/_doc/2": true})
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := writeScript(t, dir, "data/cars.jsonl", "# id: id\n{\"id\":1}\n{\"id\":2}\n{\"make\":\"Ford\"}\n{\"id\":3}")
+	writeScript(t, dir, seedMappingFile, "data/*.json -> broken\n")
+	broken := writeScript(t, dir, "data/broken.json", `{"id": 1}`)
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	seeder.BatchSize = 2
+	results, err := seeder.Seed()
+	require.NoError(t, err)
+
+	assert.Contains(t, results, "Error: "+broken+"\n"+broken+": expecting an array of documents")
+	var failure string
+	for _, r := range results {
+		if strings.HasPrefix(r, "Error: "+file) {
+			failure = r
+		}
+	}
+	assert.Contains(t, failure, "2 of 4 documents failed")
+	assert.NotContains(t, records, "data/cars.jsonl")
+
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+	poisoned, _ := filepath.Glob(filepath.Join(dir, poisonDir, "*", "data", "cars.ndjson"))
+	require.Len(t, poisoned, 1)
+	b, err := ioutil.ReadFile(poisoned[0])
+	require.NoError(t, err)
+	//the document without an id fails before the batch is sent
+	assert.Equal(t, "// index: cars\n// id: id\n{\"make\":\"Ford\"}\n{\"id\":2}\n", string(b))
+	poisoned, _ = filepath.Glob(filepath.Join(dir, poisonDir, "*", "data", "broken.json"))
+	assert.Len(t, poisoned, 1)
+}
+
+func TestSeedDataFileSendsOnlyFailedDocumentsAgain(t *testing.T) {
+	for _, batch := range []int{1, 10} {
+		ts, records, docs := seedServer(map[string]bool{"/logs/_doc/data/logs.ndjson#2": true})
+		dir, err := ioutil.TempDir("", "esdeploy")
+		require.NoError(t, err)
+
+		writeScript(t, dir, "data/logs.ndjson", "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n")
+		seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+		seeder.Keep = true
+		seeder.BatchSize = batch
+		for run := 0; run < 2; run++ {
+			_, err := seeder.Seed()
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 1, docs["/logs/_doc/data/logs.ndjson#1"])
+		assert.Equal(t, 2, docs["/logs/_doc/data/logs.ndjson#2"])
+		assert.Equal(t, 1, docs["/logs/_doc/data/logs.ndjson#3"])
+		assert.Contains(t, records, "logs#data/logs.ndjson#1")
+		assert.NotContains(t, records, "logs#data/logs.ndjson#2")
+		assert.NotContains(t, records, "data/logs.ndjson")
+
+		//the failed document keeps its id in the poison folder
+		seeder.Keep = false
+		_, err = seeder.Seed()
+		require.NoError(t, err)
+		poisoned, _ := filepath.Glob(filepath.Join(dir, poisonDir, "*", "data", "logs.ndjson"))
+		require.Len(t, poisoned, 1)
+		b, err := ioutil.ReadFile(poisoned[0])
+		require.NoError(t, err)
+		assert.Equal(t, "// index: logs\n// id: _id\n{\"_id\":\"data/logs.ndjson#2\",\"n\":2}\n", string(b))
+
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSeedDataFileNullID(t *testing.T) {
+	ts, _, docs := seedServer(nil)
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeScript(t, dir, seedMappingFile, "data/*.json -> cars id\n")
+	ndjson := writeScript(t, dir, "data/cars.ndjson", "// id: id\n{\"id\":1}\n\n{\"id\":null}\n")
+	array := writeScript(t, dir, "data/cars.json", "[\n  {\"id\": 2},\n  {\"id\": {\"a\": 1}}\n]")
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	seeder.Keep = true
+	results, err := seeder.Seed()
+	require.NoError(t, err)
+
+	failures := strings.Join(results, "\n")
+	assert.Contains(t, failures, "Error: "+ndjson+"\n1 of 2 documents failed, first failure document 2 (line 4): id field is null, not a string or number")
+	assert.Contains(t, failures, "Error: "+array+"\n1 of 2 documents failed, first failure document 2 (line 3): id field is an object, not a string or number")
+	assert.Equal(t, 1, docs["/cars/_doc/1"])
+	assert.Equal(t, 1, docs["/cars/_doc/2"])
+	for path := range docs {
+		assert.NotContains(t, path, "#")
+	}
+}
+
+func TestReadSeedMappings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mappings, err := readSeedMappings(dir)
+	require.NoError(t, err)
+	assert.Empty(t, mappings)
+
+	writeScript(t, dir, seedMappingFile, "cars/*.csv -> cars vin\n**/*.ndjson -> logs\n")
+	mappings, err = readSeedMappings(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []seedMapping{{"cars/*.csv", "cars", "vin", ""}, {"**/*.ndjson", "logs", "", ""}}, mappings)
+
+	writeScript(t, dir, seedMappingFile, "# comment\ncars.csv cars\n")
+	_, err = readSeedMappings(dir)
+	assert.EqualError(t, err, filepath.Join(dir, seedMappingFile)+`:2: expecting glob -> index [id field] [op=operation] but was "cars.csv cars"`)
+}
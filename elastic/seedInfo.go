@@ -0,0 +1,260 @@
+package elastic
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Seeded files are tracked in their own index so they are not mixed
+// with the schema changes in esdeploy_v1
+const seedIndex = "esdeploy_seed_v1"
+
+// SeedInfo is the record stored in elastic search for each seeded file
+type SeedInfo struct {
+	ID         string    `json:"id"` //Path of the file relative to the seed folder
+	File       string    `json:"file"`
+	URL        string    `json:"url"`
+	Hash       string    `json:"hash"` //sha256 of the file when it was seeded
+	Size       int64     `json:"size"`
+	Machine    string    `json:"machine"`
+	DateRunUtc time.Time `json:"dateRunUtc"`
+}
+
+// newSeedInfo builds the record of a seed file, named after its path rel
+// relative to the seed folder, with the hash of its content
+func (s *Seeder) newSeedInfo(file, rel string, a Action) (*SeedInfo, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, err
+	}
+	s.template.hash(hash)
+
+	h, _ := os.Hostname()
+	return &SeedInfo{
+		ID:         rel,
+		File:       filepath.Base(file),
+		URL:        a.URL,
+		Hash:       hex.EncodeToString(hash.Sum(nil)),
+		Size:       size,
+		Machine:    h,
+		DateRunUtc: time.Now().UTC(),
+	}, nil
+}
+
+// seededHashes looks up the records of the IDs with a single _mget and
+// returns the hash each was last seeded with. IDs never seeded are missing
+func (s *Seeder) seededHashes(ids []string) (map[string]string, error) {
+	b, _ := json.Marshal(map[string][]string{"ids": ids})
+	resp, err := s.request("POST", seedIndex+"/_mget", b)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	hashes := make(map[string]string)
+	switch resp.StatusCode {
+	case 404:
+		return hashes, nil //the index is created with the first record
+	case 200:
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, errors.New(string(body))
+	}
+	var result struct {
+		Docs []struct {
+			ID     string   `json:"_id"`
+			Found  bool     `json:"found"`
+			Source SeedInfo `json:"_source"`
+		} `json:"docs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	for _, doc := range result.Docs {
+		if doc.Found {
+			hashes[doc.ID] = doc.Source.Hash
+		}
+	}
+	return hashes, nil
+}
+
+// recordSeeds writes the records of seeded files with a single _bulk
+// request. Returns the IDs of the records that were not written
+func (s *Seeder) recordSeeds(infos []*SeedInfo) ([]string, error) {
+	var b bytes.Buffer
+	ids := make([]string, len(infos))
+	for i, info := range infos {
+		ids[i] = info.ID
+		//typeless, so the records are _doc documents and elastic search 8 accepts them
+		meta, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_id": info.ID}})
+		doc, _ := json.Marshal(info)
+		b.Write(meta)
+		b.WriteString("\n")
+		b.Write(doc)
+		b.WriteString("\n")
+	}
+	resp, err := s.request("POST", seedIndex+"/_bulk", b.Bytes())
+	if err != nil {
+		return ids, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ids, err
+	}
+	if resp.StatusCode != 200 {
+		return ids, errors.New(string(body))
+	}
+	results, err := bulkItemResults(body, len(infos))
+	if err != nil {
+		return ids, err
+	}
+	var failed []string
+	var first error
+	for i, result := range results {
+		if result.Error != nil || (result.Status != 200 && result.Status != 201) {
+			failed = append(failed, ids[i])
+			if first == nil {
+				first = fmt.Errorf("%d %s", result.Status, result.Error)
+			}
+		}
+	}
+	return failed, first
+}
+
+// lookup passes a seed file on to be sent unless it was already seeded with
+// the same content. The records of BatchSize files are looked up at a time
+func (r *seedRun) lookup(item seedItem) {
+	if r.seeder.Force {
+		r.sendFile(item)
+		return
+	}
+	r.lookupMu.Lock()
+	r.lookups = append(r.lookups, item)
+	var items []seedItem
+	if len(r.lookups) >= r.seeder.recordBatch() {
+		items, r.lookups = r.lookups, nil
+	}
+	r.lookupMu.Unlock()
+	r.checkSeeded(items)
+}
+
+// flushLookups looks up the records of the files still waiting, and of the
+// documents of the data files they send
+func (r *seedRun) flushLookups() {
+	for {
+		r.lookupMu.Lock()
+		items := r.lookups
+		r.lookups = nil
+		r.lookupMu.Unlock()
+		if len(items) == 0 {
+			return
+		}
+		r.checkSeeded(items)
+	}
+}
+
+// checkSeeded looks up the records of the files and sends the ones that
+// are new or changed
+func (r *seedRun) checkSeeded(items []seedItem) {
+	if len(items) == 0 {
+		return
+	}
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.info.ID
+	}
+	hashes, err := r.seeder.seededHashes(ids)
+	for _, item := range items {
+		switch {
+		case err != nil:
+			r.finish(item, false, err)
+		case hashes[item.info.ID] == item.info.Hash:
+			r.finish(item, true, nil)
+		default:
+			r.sendFile(item)
+		}
+	}
+}
+
+// queueRecord adds the record of a seeded file to those written with the
+// next _bulk request, which is sent once there are BatchSize records
+func (r *seedRun) queueRecord(info *SeedInfo) {
+	r.recordMu.Lock()
+	r.records = append(r.records, info)
+	var infos []*SeedInfo
+	if len(r.records) >= r.seeder.recordBatch() {
+		infos, r.records = r.records, nil
+	}
+	r.recordMu.Unlock()
+	r.writeRecords(infos)
+}
+
+// flushRecords writes the records still waiting, as each batch of
+// documents completes and at the end of the run
+func (r *seedRun) flushRecords() {
+	r.recordMu.Lock()
+	infos := r.records
+	r.records = nil
+	r.recordMu.Unlock()
+	r.writeRecords(infos)
+}
+
+func (r *seedRun) writeRecords(infos []*SeedInfo) {
+	if len(infos) == 0 {
+		return
+	}
+	failed, err := r.seeder.recordSeeds(infos)
+	if err != nil {
+		r.mu.Lock()
+		r.results = append(r.results, "Error recording seed: "+strings.Join(failed, ", ")+"\n"+err.Error())
+		r.mu.Unlock()
+	}
+}
+
+// recordBatch is the number of seed records read or written in a request
+func (s *Seeder) recordBatch() int {
+	if s.BatchSize > 1 {
+		return s.BatchSize
+	}
+	return 1
+}
+
+// request sends a JSON request to path on the server
+func (s *Seeder) request(method, path string, body []byte) (*http.Response, error) {
+	url := joinURL(s.ServerURL, path)
+	return s.do(func() (*http.Request, error) {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, url, r)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Accept", "application/json")
+		if body != nil {
+			req.Header.Add("Content-Type", "application/json")
+		}
+		if s.Creds.AuthorizationNeeded() {
+			req.SetBasicAuth(s.Creds.Username, s.Creds.Password)
+		}
+		return req, nil
+	}, int64(len(body)))
+}
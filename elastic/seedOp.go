@@ -0,0 +1,82 @@
+package elastic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Seed operations, chosen with the op header of a seed file or op= in the
+// seed mapping file
+const (
+	SeedOpIndex  = "index"  //Creates or replaces the document, the default
+	SeedOpCreate = "create" //Only creates the document, fails when it already exists
+	SeedOpUpdate = "update" //Updates the fields of an existing document
+	SeedOpUpsert = "upsert" //Updates the fields of the document, creating it when missing
+)
+
+func validSeedOp(op string) error {
+	switch op {
+	case "", SeedOpIndex, SeedOpCreate, SeedOpUpdate, SeedOpUpsert:
+		return nil
+	}
+	return fmt.Errorf("unknown seed operation %q, expecting index, create, update or upsert", op)
+}
+
+// seedOperation changes an action indexing a document (PUT cars/_doc/1)
+// into the operation. Update and upsert send the document as the doc of a
+// partial update
+func seedOperation(op string, a Action) (Action, error) {
+	if err := validSeedOp(op); err != nil {
+		return a, err
+	}
+	switch op {
+	case SeedOpCreate:
+		sep := "?"
+		if strings.Contains(a.URL, "?") {
+			sep = "&"
+		}
+		a.URL += sep + "op_type=create"
+	case SeedOpUpdate, SeedOpUpsert:
+		parts := strings.Split(strings.Trim(a.URL, "/"), "/")
+		if len(parts) != 3 || strings.Contains(a.URL, "?") {
+			return a, fmt.Errorf("the %s operation needs a document url (index/_doc/id) but was %s", op, a.URL)
+		}
+		if parts[1] == "_doc" {
+			a.URL = parts[0] + "/_update/" + parts[2]
+		} else {
+			a.URL = strings.Join(parts, "/") + "/_update" //elastic search 6 and earlier
+		}
+		a.HTTPVerb = "POST"
+		a.bodyBefore, a.bodyAfter = `{"doc":`, "}"
+		if op == SeedOpUpsert {
+			a.bodyAfter = `,"doc_as_upsert":true}`
+		}
+	}
+	return a, nil
+}
+
+// seedSuccess determines if the status is a success for the seed request.
+// Creating a document only succeeds with 201, updating or replacing one
+// also with 200
+func seedSuccess(a Action, status int) bool {
+	create := strings.Contains(a.URL, "op_type=create") || strings.Contains(a.URL, "/_create/") ||
+		(a.HTTPVerb == "POST" && strings.HasSuffix(strings.TrimRight(a.URL, "/"), "/_doc"))
+	if create {
+		return status == 201
+	}
+	return status == 200 || status == 201
+}
+
+// seedHeaderLine reads a comment line (// or #) at the top of a seed file
+// into header. Returns false when the line is not a comment
+func seedHeaderLine(line string, header map[string]string) bool {
+	text := strings.TrimSpace(line)
+	if !strings.HasPrefix(text, "#") && !strings.HasPrefix(text, headerPrefix) {
+		return false
+	}
+	text = strings.TrimLeft(text, "/#")
+	if parts := strings.SplitN(text, ":", 2); len(parts) == 2 {
+		header[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	return true
+}
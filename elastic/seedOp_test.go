@@ -0,0 +1,118 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedOperation(t *testing.T) {
+	doc := Action{HTTPVerb: "PUT", URL: "cars/_doc/1", JSON: `{"make":"Ford"}`}
+	for _, tc := range []struct {
+		op, verb, url, body string
+	}{
+		{"", "PUT", "cars/_doc/1", `{"make":"Ford"}`},
+		{SeedOpIndex, "PUT", "cars/_doc/1", `{"make":"Ford"}`},
+		{SeedOpCreate, "PUT", "cars/_doc/1?op_type=create", `{"make":"Ford"}`},
+		{SeedOpUpdate, "POST", "cars/_update/1", `{"doc":{"make":"Ford"}}`},
+		{SeedOpUpsert, "POST", "cars/_update/1", `{"doc":{"make":"Ford"},"doc_as_upsert":true}`},
+	} {
+		a, err := seedOperation(tc.op, doc)
+		require.NoError(t, err, tc.op)
+		body, err := a.body()
+		require.NoError(t, err)
+		b, _ := ioutil.ReadAll(body)
+		assert.Equal(t, []string{tc.verb, tc.url, tc.body}, []string{a.HTTPVerb, a.URL, string(b)}, tc.op)
+	}
+
+	a, err := seedOperation(SeedOpUpdate, Action{HTTPVerb: "PUT", URL: "cars/car/1"})
+	require.NoError(t, err)
+	assert.Equal(t, "cars/car/1/_update", a.URL)
+	_, err = seedOperation(SeedOpUpdate, Action{HTTPVerb: "POST", URL: "cars/_doc"})
+	assert.EqualError(t, err, "the update operation needs a document url (index/_doc/id) but was cars/_doc")
+	_, err = seedOperation("replace", doc)
+	assert.EqualError(t, err, `unknown seed operation "replace", expecting index, create, update or upsert`)
+
+	assert.True(t, seedSuccess(doc, 200))
+	assert.True(t, seedSuccess(doc, 201))
+	assert.False(t, seedSuccess(Action{HTTPVerb: "PUT", URL: "cars/_doc/1?op_type=create"}, 200))
+	assert.False(t, seedSuccess(Action{HTTPVerb: "POST", URL: "cars/_doc"}, 200))
+	assert.True(t, seedSuccess(Action{HTTPVerb: "POST", URL: "cars/_update/1"}, 200))
+}
+
+func TestSeedOperations(t *testing.T) {
+	for _, batch := range []int{1, 10} {
+		var mu sync.Mutex
+		var requests []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if seedRecords(w, r, nil) {
+				return
+			}
+			b, _ := ioutil.ReadAll(r.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			if r.URL.Path == "/_bulk" {
+				requests = append(requests, strings.Split(strings.TrimSpace(string(b)), "\n")...)
+				//the second document exists so it can not be created
+				w.Write([]byte(`{"items":[{"create":{"status":201}},{"create":{"status":409,"error":{"type":"version_conflict_engine_exception"}}},{"index":{"status":201}},{"update":{"status":200}}]}`))
+				return
+			}
+			requests = append(requests, r.Method+" "+r.URL.RequestURI()+" "+string(b))
+			switch {
+			case r.URL.RawQuery == "op_type=create" && r.URL.Path == "/cars/_doc/2":
+				w.WriteHeader(409)
+				w.Write([]byte(`{"error":{"type":"version_conflict_engine_exception"},"status":409}`))
+			case r.Method == "POST" && r.URL.Path == "/cars/_doc":
+				w.WriteHeader(201)
+			default:
+				w.WriteHeader(200) //not a success for create
+			}
+		}))
+		dir, err := ioutil.TempDir("", "esdeploy")
+		require.NoError(t, err)
+
+		writeScript(t, dir, seedMappingFile, "trucks/** -> op=upsert\n")
+		one := writeScript(t, dir, "cars/1.js", "// op: create\ncars/_doc/1\n{}")
+		two := writeScript(t, dir, "cars/2.js", "// op: create\ncars/_doc/2\n{}")
+		three := writeScript(t, dir, "cars/3.js", "POST cars/_doc\n{}")
+		truck := writeScript(t, dir, "trucks/1.js", "trucks/_doc/1\n{ \"wheels\": 6 }")
+
+		seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+		seeder.Keep = true
+		seeder.BatchSize = batch
+		results, err := seeder.Seed()
+		require.NoError(t, err)
+
+		if batch == 1 {
+			assert.Equal(t, []string{
+				"PUT /cars/_doc/1?op_type=create {}",
+				"PUT /cars/_doc/2?op_type=create {}",
+				"POST /cars/_doc {}",
+				"POST /trucks/_update/1 {\"doc\":{ \"wheels\": 6 },\"doc_as_upsert\":true}",
+			}, requests)
+			assert.Equal(t, "Error: "+one+"\n", results[0], "200 is not a success for create")
+		} else {
+			assert.Equal(t, []string{
+				`{"create":{"_id":"1","_index":"cars"}}`, `{}`,
+				`{"create":{"_id":"2","_index":"cars"}}`, `{}`,
+				`{"index":{"_index":"cars"}}`, `{}`,
+				`{"update":{"_id":"1","_index":"trucks"}}`, `{"doc":{"wheels":6},"doc_as_upsert":true}`,
+			}, requests)
+			assert.Equal(t, "Success: "+one, results[0])
+		}
+		assert.True(t, strings.HasPrefix(results[1], "Error: "+two+"\n"), results[1])
+		assert.Contains(t, results[1], "version_conflict_engine_exception")
+		assert.Equal(t, "Success: "+three, results[2])
+		assert.Equal(t, "Success: "+truck, results[3])
+
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
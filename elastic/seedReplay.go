@@ -0,0 +1,176 @@
+package elastic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// poisonErrorExt is added to the name of a file in the poison folder for
+// the file holding the error it failed with, and poisonPathExt for the file
+// holding its path relative to the seed folder, as the poison folder only
+// keeps the name of its parent folder
+const (
+	poisonErrorExt = ".error"
+	poisonPathExt  = ".path"
+)
+
+// PoisonBatch is a folder within the poison folder holding the files that
+// failed in one run of Seed, named after the time it started
+type PoisonBatch struct {
+	Name  string
+	Dir   string
+	Files []PoisonFile
+}
+
+// PoisonFile is a file that failed to seed
+type PoisonFile struct {
+	File  string //Path relative to the batch folder
+	Path  string //Path relative to the seed folder it was seeded from
+	Error string //The error or response it failed with
+}
+
+func writePoisonError(file, msg string) error {
+	return ioutil.WriteFile(file+poisonErrorExt, []byte(msg), 0666)
+}
+
+func writePoisonPath(file, rel string) error {
+	return ioutil.WriteFile(file+poisonPathExt, []byte(rel), 0666)
+}
+
+// PoisonBatches lists the batches in the poison folder, oldest first.
+// Batches without files are left out
+func (s *Seeder) PoisonBatches() ([]PoisonBatch, error) {
+	root := filepath.Join(s.Directory, poisonDir)
+	dirs, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var batches []PoisonBatch
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		batch := PoisonBatch{Name: d.Name(), Dir: filepath.Join(root, d.Name())}
+		err := filepath.Walk(batch.Dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || strings.HasSuffix(path, poisonErrorExt) || strings.HasSuffix(path, poisonPathExt) {
+				return err
+			}
+			rel, _ := filepath.Rel(batch.Dir, path)
+			f := PoisonFile{File: filepath.ToSlash(rel), Path: filepath.ToSlash(rel)}
+			msg, _ := ioutil.ReadFile(path + poisonErrorExt)
+			f.Error = string(msg)
+			if orig, err := ioutil.ReadFile(path + poisonPathExt); err == nil {
+				f.Path = string(orig)
+			}
+			batch.Files = append(batch.Files, f)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(batch.Files) > 0 {
+			batches = append(batches, batch)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].Name < batches[j].Name })
+	return batches, nil
+}
+
+// Replay seeds the files of the named poison batches again, or of every
+// batch when no names are given. Files that are seeded, or were seeded since,
+// are removed along with their error and a batch is removed once it is
+// empty. Files that fail again stay in the batch with their new error
+func (s *Seeder) Replay(names []string) ([]string, error) {
+	var results []string
+	batches, err := s.PoisonBatches()
+	if err != nil {
+		return results, err
+	}
+	selected := batches
+	if len(names) > 0 {
+		selected = nil
+		for _, name := range names {
+			found := false
+			for _, b := range batches {
+				if b.Name == name {
+					selected = append(selected, b)
+					found = true
+				}
+			}
+			if !found {
+				return results, fmt.Errorf("no poison batch %s in %s", name, filepath.Join(s.Directory, poisonDir))
+			}
+		}
+	}
+
+	//the operations of the seed folder still apply, and the files are
+	//recorded and matched by the path they were seeded from
+	mappings, err := readSeedMappings(s.Directory)
+	if err != nil {
+		return results, err
+	}
+	for _, b := range selected {
+		replay := *s
+		replay.Directory = b.Dir
+		replay.Keep = true
+		replay.ReportFile = ""
+		paths := make(map[string]string)
+		for _, f := range b.Files {
+			paths[filepath.Join(b.Dir, filepath.FromSlash(f.File))] = f.Path
+		}
+		run, err := replay.seed(mappings, paths)
+		results = append(results, run.results...)
+		if err != nil {
+			return results, err
+		}
+
+		seeded, failed := 0, 0
+		for _, outcome := range run.report.Files {
+			if outcome.Error != "" {
+				failed++
+				err = writePoisonError(outcome.File, outcome.Error)
+			} else {
+				seeded++
+				err = os.Remove(outcome.File)
+				if err == nil {
+					err = os.Remove(outcome.File + poisonErrorExt)
+				}
+				if err == nil || os.IsNotExist(err) {
+					err = os.Remove(outcome.File + poisonPathExt)
+				}
+			}
+			if err != nil && !os.IsNotExist(err) {
+				return results, err
+			}
+		}
+		results = append(results, fmt.Sprintf("Replayed batch %s: %d seeded, %d failed", b.Name, seeded, failed))
+		if removeEmptyDirs(b.Dir) {
+			results = append(results, "Removed batch "+b.Name)
+		}
+	}
+	return results, nil
+}
+
+// removeEmptyDirs removes the folders under dir, and dir itself, which are
+// empty. Returns if dir was removed
+func removeEmptyDirs(dir string) bool {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	empty := true
+	for _, e := range entries {
+		if !e.IsDir() || !removeEmptyDirs(filepath.Join(dir, e.Name())) {
+			empty = false
+		}
+	}
+	return empty && os.Remove(dir) == nil
+}
@@ -0,0 +1,120 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedReplay(t *testing.T) {
+	failures := map[string]bool{"/cars/_doc/2": true, "/cars/_doc/3": true}
+	ts, records, docs := seedServer(failures)
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeScript(t, dir, "cars/1.js", "cars/_doc/1\n{}")
+	writeScript(t, dir, "cars/2.js", "cars/_doc/2\n{}")
+	writeScript(t, dir, "cars/3.js", "cars/_doc/3\n{}")
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	_, err = seeder.Seed()
+	require.NoError(t, err)
+
+	batches, err := seeder.PoisonBatches()
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	batch := batches[0]
+	assert.Equal(t, filepath.Join(dir, poisonDir, batch.Name), batch.Dir)
+	assert.Equal(t, []PoisonFile{
+		{File: "cars/2.js", Path: "cars/2.js", Error: `{"error":{"type":"mapper_parsing_exception"},"status":400}`},
+		{File: "cars/3.js", Path: "cars/3.js", Error: `{"error":{"type":"mapper_parsing_exception"},"status":400}`},
+	}, batch.Files)
+
+	_, err = seeder.Replay([]string{"19990101000000"})
+	assert.EqualError(t, err, "no poison batch 19990101000000 in "+filepath.Join(dir, poisonDir))
+
+	//the second file is fixed, the third fails again
+	delete(failures, "/cars/_doc/2")
+	results, err := seeder.Replay([]string{batch.Name})
+	require.NoError(t, err)
+	assert.Contains(t, results, "Replayed batch "+batch.Name+": 1 seeded, 1 failed")
+	assert.Equal(t, 2, docs["/cars/_doc/2"])
+	assert.Contains(t, records, "cars/2.js")
+	batches, err = seeder.PoisonBatches()
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, []PoisonFile{{File: "cars/3.js", Path: "cars/3.js", Error: `{"error":{"type":"mapper_parsing_exception"},"status":400}`}}, batches[0].Files)
+	_, err = os.Stat(filepath.Join(batch.Dir, "cars", "2.js"+poisonErrorExt))
+	assert.True(t, os.IsNotExist(err))
+
+	//every batch once they are all fixed
+	delete(failures, "/cars/_doc/3")
+	results, err = seeder.Replay(nil)
+	require.NoError(t, err)
+	assert.Contains(t, results, "Replayed batch "+batch.Name+": 1 seeded, 0 failed")
+	assert.Contains(t, results, "Removed batch "+batch.Name)
+	_, err = os.Stat(batch.Dir)
+	assert.True(t, os.IsNotExist(err))
+	batches, err = seeder.PoisonBatches()
+	require.NoError(t, err)
+	assert.Empty(t, batches)
+}
+
+func TestSeedWithoutFailuresHasNoPoisonBatch(t *testing.T) {
+	ts, _, _ := seedServer(nil)
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeScript(t, dir, "cars/1.js", "cars/_doc/1\n{}")
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	_, err = seeder.Seed()
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, poisonDir))
+	assert.True(t, os.IsNotExist(err))
+
+	//an empty batch left behind is not listed
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, poisonDir, "20200101000000", "cars"), 0777))
+	batches, err := seeder.PoisonBatches()
+	require.NoError(t, err)
+	assert.Empty(t, batches)
+}
+
+func TestSeedReplayKeepsOriginalPaths(t *testing.T) {
+	failures := map[string]bool{"/cars/_update/9": true}
+	ts, records, docs := seedServer(failures)
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeScript(t, dir, seedMappingFile, "reference/**/*.js -> cars op=update\n")
+	writeScript(t, dir, "reference/cars/9.js", "cars/_doc/9\n{}")
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	_, err = seeder.Seed()
+	require.NoError(t, err)
+
+	//the poison folder only keeps the parent folder
+	batches, err := seeder.PoisonBatches()
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	require.Len(t, batches[0].Files, 1)
+	assert.Equal(t, "cars/9.js", batches[0].Files[0].File)
+	assert.Equal(t, "reference/cars/9.js", batches[0].Files[0].Path)
+
+	delete(failures, "/cars/_update/9")
+	results, err := seeder.Replay(nil)
+	require.NoError(t, err)
+	assert.Contains(t, results, "Replayed batch "+batches[0].Name+": 1 seeded, 0 failed")
+	assert.Equal(t, 2, docs["/cars/_update/9"])
+	assert.Contains(t, records, "reference/cars/9.js")
+	assert.NotContains(t, records, "cars/9.js")
+	_, err = os.Stat(batches[0].Dir)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,162 @@
+package elastic
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxTokenLength is the longest {{token}} replaced in a seed document
+const maxTokenLength = 256
+
+// seedTemplate replaces the {{tokens}} of seed files, like {{shards}} and
+// {{replicas}} in schema files. The values given with --var come first,
+// then the built in tokens
+//
+//	{{now}}       the time seeding started, 2006-01-02T15:04:05Z
+//	{{today}}     the date seeding started, 2006-01-02
+//	{{uuid}}      a new random UUID each time it is used
+//	{{env.NAME}}  the environment variable NAME, which has to be set
+//
+// Other tokens are sent as is, so mustache templates can still be seeded
+type seedTemplate struct {
+	vars map[string]string
+	now  time.Time
+}
+
+func newSeedTemplate(vars map[string]string, now time.Time) *seedTemplate {
+	return &seedTemplate{vars: vars, now: now.UTC()}
+}
+
+// value returns the value of a token, false when it is not known
+func (t *seedTemplate) value(name string) (string, bool, error) {
+	if v, ok := t.vars[name]; ok {
+		return v, true, nil
+	}
+	switch {
+	case name == "now":
+		return t.now.Format(time.RFC3339), true, nil
+	case name == "today":
+		return t.now.Format("2006-01-02"), true, nil
+	case name == "uuid":
+		id, err := newUUID()
+		return id, true, err
+	case strings.HasPrefix(name, "env."):
+		v, ok := os.LookupEnv(strings.TrimPrefix(name, "env."))
+		if !ok {
+			return "", false, fmt.Errorf("environment variable %s for {{%s}} is not set", strings.TrimPrefix(name, "env."), name)
+		}
+		return v, true, nil
+	}
+	return "", false, nil
+}
+
+// replace replaces the tokens of text
+func (t *seedTemplate) replace(text string) (string, error) {
+	if t == nil {
+		return text, nil
+	}
+	var err error
+	text = tokenPattern.ReplaceAllStringFunc(text, func(token string) string {
+		v, ok, e := t.value(tokenPattern.FindStringSubmatch(token)[1])
+		if e != nil && err == nil {
+			err = e
+		}
+		if !ok {
+			return token
+		}
+		return v
+	})
+	return text, err
+}
+
+// reader replaces the tokens of a body while it is streamed
+func (t *seedTemplate) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &tokenReader{r: bufio.NewReader(r), value: t.value}
+}
+
+// hash adds the --var values to the hash of a seed file, so changing them
+// seeds the file again
+func (t *seedTemplate) hash(w io.Writer) {
+	if t == nil {
+		return
+	}
+	names := make([]string, 0, len(t.vars))
+	for name := range t.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "\n%s=%s", name, t.vars[name])
+	}
+}
+
+// tokenReader replaces the {{tokens}} of a stream, reading ahead at most
+// maxTokenLength bytes after each {{. Tokens without a value are left as is
+type tokenReader struct {
+	r     *bufio.Reader
+	value func(name string) (string, bool, error)
+	out   []byte //replaced text not read yet
+	err   error
+}
+
+func (t *tokenReader) Read(p []byte) (int, error) {
+	for len(t.out) == 0 {
+		if t.err != nil {
+			return 0, t.err
+		}
+		t.fill()
+	}
+	n := copy(p, t.out)
+	t.out = t.out[n:]
+	return n, nil
+}
+
+// fill reads up to the next { and replaces the token it starts, if any
+func (t *tokenReader) fill() {
+	text, err := t.r.ReadBytes('{')
+	t.out = text
+	if err != nil {
+		t.err = err
+		return
+	}
+	ahead, _ := t.r.Peek(maxTokenLength)
+	end := bytes.Index(ahead, []byte("}}"))
+	if len(ahead) == 0 || ahead[0] != '{' || end < 0 {
+		return
+	}
+	token := "{" + string(ahead[:end+2])
+	match := tokenPattern.FindStringSubmatch(token)
+	if match == nil || match[0] != token {
+		return
+	}
+	v, ok, err := t.value(match[1])
+	if err != nil {
+		t.err = err
+		return
+	}
+	if ok {
+		t.r.Discard(end + 2)
+		t.out = append(text[:len(text)-1], v...)
+	}
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,82 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedTemplate(t *testing.T) {
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.FixedZone("NZ", 13*3600))
+	tmpl := newSeedTemplate(map[string]string{"tenant": "acme", "now": "fixed"}, now)
+	os.Setenv("ESDEPLOY_TEST_SUFFIX", "stg")
+	defer os.Unsetenv("ESDEPLOY_TEST_SUFFIX")
+
+	text, err := tmpl.replace(`{{tenant}} {{ env.ESDEPLOY_TEST_SUFFIX }} {{today}} {{now}} {{query}}`)
+	require.NoError(t, err)
+	assert.Equal(t, `acme stg 2026-03-03 fixed {{query}}`, text, "vars win over built in tokens, unknown tokens are kept")
+
+	text, err = newSeedTemplate(nil, now).replace(`{{now}} {{uuid}} {{uuid}}`)
+	require.NoError(t, err)
+	parts := strings.Split(text, " ")
+	assert.Equal(t, "2026-03-03T16:06:07Z", parts[0])
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	assert.Regexp(t, uuid, parts[1])
+	assert.NotEqual(t, parts[1], parts[2])
+
+	_, err = tmpl.replace(`{{env.ESDEPLOY_TEST_MISSING}}`)
+	assert.EqualError(t, err, "environment variable ESDEPLOY_TEST_MISSING for {{env.ESDEPLOY_TEST_MISSING}} is not set")
+
+	var none *seedTemplate
+	text, err = none.replace("{{now}}")
+	require.NoError(t, err)
+	assert.Equal(t, "{{now}}", text)
+}
+
+func TestTokenReader(t *testing.T) {
+	tmpl := newSeedTemplate(map[string]string{"x": "1", "tenant": "acme"}, time.Now())
+	long := "{{" + strings.Repeat("a", maxTokenLength) + "}}"
+	in := `{"a": {{x}}, "b": "{{{x}}}", "c": "{{ tenant }}", "d": "{{other}}", "e": "` + long + `", "f": {}}` + "{{x}}"
+	b, err := ioutil.ReadAll(tmpl.reader(iotest.OneByteReader(strings.NewReader(in))))
+	require.NoError(t, err)
+	assert.Equal(t, `{"a": 1, "b": "{1}", "c": "acme", "d": "{{other}}", "e": "`+long+`", "f": {}}1`, string(b))
+
+	_, err = ioutil.ReadAll(tmpl.reader(strings.NewReader(`{"a": "{{env.ESDEPLOY_TEST_MISSING}}"}`)))
+	assert.EqualError(t, err, "environment variable ESDEPLOY_TEST_MISSING for {{env.ESDEPLOY_TEST_MISSING}} is not set")
+}
+
+func TestSeedWithVars(t *testing.T) {
+	ts, records, docs := seedServer(nil)
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeScript(t, dir, "cars/1.js", "cars_{{suffix}}/_doc/{{tenant}}-1\n{ \"tenant\": \"{{tenant}}\", \"seeded\": \"{{today}}\" }")
+	writeScript(t, dir, "logs.ndjson", "// index: logs_{{suffix}}\n// id: id\n{\"id\": \"{{tenant}}\", \"run\": \"{{uuid}}\"}")
+
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	seeder.Keep = true
+	seeder.Vars = map[string]string{"suffix": "stg", "tenant": "acme"}
+	_, err = seeder.Seed()
+	require.NoError(t, err)
+	assert.Equal(t, 1, docs["/cars_stg/_doc/acme-1"])
+	assert.Equal(t, 1, docs["/logs_stg/_doc/acme"])
+
+	//unchanged with the same values, seeded again when they change
+	_, err = seeder.Seed()
+	require.NoError(t, err)
+	assert.Equal(t, 1, docs["/cars_stg/_doc/acme-1"])
+	seeder.Vars["tenant"] = "globex"
+	_, err = seeder.Seed()
+	require.NoError(t, err)
+	assert.Equal(t, 1, docs["/cars_stg/_doc/globex-1"])
+	assert.Contains(t, records["cars/1.js"], `"url":"cars_stg/_doc/globex-1"`)
+}
@@ -1,161 +1,473 @@
 package elastic
 
 import (
-	"bufio"
-	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// poisonDir is the folder within the seed folder that files which
+// failed to seed are moved to
+const poisonDir = "poison"
+
 // Seeder handles seeding elastic search with data
 type Seeder struct {
-	Creds      Creds
-	HTTPClient *http.Client
-	Directory  string
-	ServerURL  string
+	Creds             Creds
+	HTTPClient        *http.Client
+	Directory         string
+	ServerURL         string
+	Compress          bool              //gzip request bodies
+	Filter            FileFilter        //Which files in Directory are seeded
+	Keep              bool              //Leave the files in place instead of removing them or moving them to the poison folder
+	ReportFile        string            //Optional, the outcome of each file is written to it as JSON
+	Force             bool              //Seed files again even when they are unchanged since they were last seeded
+	BatchSize         int               //Documents per _bulk request, 0 or 1 sends a request per file
+	BatchBytes        int               //Optional limit on the size of a _bulk request
+	Workers           int               //Requests sent at the same time, 0 or 1 seeds one file at a time
+	RequestsPerSecond float64           //Optional limit on the requests sent to the server
+	BytesPerSecond    int64             //Optional limit on the bytes sent to the server
+	Retries           int               //Attempts at a request rejected with 429 Too Many Requests or that can not connect
+	Backoff           time.Duration     //Wait before sending a rejected request again, doubles each attempt
+	Timeout           time.Duration     //Optional limit on how long each request takes
+	Vars              map[string]string //Values of the {{tokens}} in seed files, see seedTemplate
+	template          *seedTemplate
+	limiter           *rateLimiter
+	unreachable       int32 //Set once a request of the run can not connect, so others fail without retrying
 }
 
-// NewSeeder will initialize a new Seeder
-func NewSeeder(directory string, serverURL string, creds Creds) *Seeder {
+// NewSeeder will initialize a new Seeder. Files are kept when the
+// directory is in a git repository
+func NewSeeder(directory string, serverURL string, creds Creds, allowInsecure bool) *Seeder {
 
 	return &Seeder{
-		HTTPClient: http.DefaultClient,
+		HTTPClient: newHTTPClient(allowInsecure),
 		Creds:      creds,
-		ServerURL:  serverURL,
+		ServerURL:  normalizeServerURL(serverURL),
 		Directory:  directory,
+		Keep:       InGitRepository(directory),
+		Retries:    5,
+		Backoff:    time.Second,
 	}
 }
 
+// SeedReport is written to the ReportFile of a Seeder
+type SeedReport struct {
+	Started   time.Time     `json:"started"`
+	ServerURL string        `json:"serverUrl"`
+	Directory string        `json:"directory"`
+	Documents int           `json:"documents"` //Number of documents seeded
+	Bytes     int64         `json:"bytes"`     //Size of the files seeded
+	Seconds   float64       `json:"seconds"`   //How long seeding took
+	Files     []SeedOutcome `json:"files"`
+}
+
+// SeedOutcome is the result of seeding a single file
+type SeedOutcome struct {
+	File      string `json:"file"`
+	Seeded    bool   `json:"seeded"`
+	Unchanged bool   `json:"unchanged,omitempty"` //Already seeded with the same content so it was not sent
+	Error     string `json:"error,omitempty"`
+}
+
 // Seed will examine all of the json files in a directory
-// and apply that document against elastic search
+// and apply that document against elastic search. Unless Keep is set
+// seeded files are deleted and failed files are moved to the poison folder.
+// When BatchSize is more than 1 the documents are sent with the _bulk API.
+// With more than one worker the order of the results is not kept
 func (s *Seeder) Seed() ([]string, error) {
-	var results []string
+	mappings, err := readSeedMappings(s.Directory)
+	if err != nil {
+		return nil, err
+	}
+	run, err := s.seed(mappings, nil)
+	return run.results, err
+}
+
+// seed seeds the files of the directory and returns the outcome. paths
+// holds the path relative to the seed folder of files which are not in it
+func (s *Seeder) seed(mappings []seedMapping, paths map[string]string) (*seedRun, error) {
 	now := time.Now()
-	p := filepath.Join(s.Directory, "poison", now.Format("20060102150405"))
+	s.limiter = newRateLimiter(s.RequestsPerSecond, s.BytesPerSecond)
+	atomic.StoreInt32(&s.unreachable, 0)
+	s.template = newSeedTemplate(s.Vars, now)
+	run := &seedRun{
+		seeder:   s,
+		poison:   filepath.Join(s.Directory, poisonDir, now.Format("20060102150405")),
+		report:   SeedReport{Started: now.UTC(), ServerURL: s.ServerURL, Directory: s.Directory},
+		batch:    new(bulkBatch),
+		sends:    newWorkerPool(s.Workers),
+		mappings: mappings,
+		paths:    paths,
+	}
 
-	if _, err := os.Stat(p); os.IsNotExist(err) {
-		err := os.MkdirAll(p, 0777)
-		if err != nil {
-			log.Panic(err)
+	files, err := run.getFiles()
+	if err != nil {
+		return run, err
+	}
+	checks := newWorkerPool(s.Workers)
+	for _, file := range files {
+		if run.failed() {
+			break
 		}
+		file := file
+		checks.run(func() { run.check(file) })
+	}
+	checks.wait()
+	run.flushLookups()
+	run.batchMu.Lock()
+	run.flush()
+	run.batchMu.Unlock()
+	run.sends.wait()
+	run.flushRecords()
+	if run.err != nil {
+		return run, run.err
 	}
 
-	files := s.getFiles(s.Directory)
-	for _, file := range files {
+	elapsed := time.Since(now)
+	run.report.Seconds = elapsed.Seconds()
+	run.results = append(run.results, fmt.Sprintf("Seeded %d documents (%d bytes) in %v, %.1f documents/s",
+		run.report.Documents, run.report.Bytes, elapsed.Round(time.Millisecond), float64(run.report.Documents)/elapsed.Seconds()))
+
+	if s.ReportFile != "" {
+		b, _ := json.MarshalIndent(run.report, "", "  ")
+		if err := ioutil.WriteFile(s.ReportFile, b, 0666); err != nil {
+			return run, err
+		}
+	}
+	return run, nil
+}
+
+// seedItem is a seed file, or a document of a data file, on its way to
+// elastic search
+type seedItem struct {
+	file   string
+	action Action
+	info   *SeedInfo //Recorded once the file is seeded
+	data   *dataFile //Set for data files
+	doc    []byte    //The document of a data file, nil for the file itself
+	n      int       //Number of the document in the data file
+}
 
-		pd := getPoisonSubDir(p, file)
-		a := s.getAction(file)
-		err := s.execute(a)
+// seedRun holds the outcome of a call to Seed
+type seedRun struct {
+	seeder   *Seeder
+	poison   string
+	mappings []seedMapping     //Index and ID field of data files
+	paths    map[string]string //Original paths of replayed files, by file
+	sends    *workerPool       //Sends the documents to elastic search
+	mu       sync.Mutex        //Guards the results, report and files
+	results  []string
+	report   SeedReport
+	err      error //Stops seeding, the files can not be moved
+	batchMu  sync.Mutex
+	batch    *bulkBatch
+	lookupMu sync.Mutex
+	lookups  []seedItem //Seed files waiting for their records to be looked up
+	recordMu sync.Mutex
+	records  []*SeedInfo //Records of seeded files not written yet
+}
 
+// check determines if a seed file has to be sent
+func (r *seedRun) check(file string) {
+	if r.isDataFile(file) {
+		r.checkData(file)
+		return
+	}
+	s := r.seeder
+	item := seedItem{file: file}
+	var err error
+	item.action, err = s.getAction(file, findSeedMapping(r.relPath(file), r.mappings).op)
+	if err == nil {
+		item.info, err = s.newSeedInfo(file, r.relPath(file), item.action)
+	}
+	if err != nil {
+		r.finish(item, false, err)
+		return
+	}
+	r.lookup(item)
+}
+
+// sendFile sends a seed file that is new or changed, or each document of a
+// data file
+func (r *seedRun) sendFile(item seedItem) {
+	if item.data != nil && item.doc == nil {
+		r.sendData(item)
+		return
+	}
+	r.send(item)
+}
+
+// send passes a seed file or document on to a worker, either on its own
+// or in a batch
+func (r *seedRun) send(item seedItem) {
+	s := r.seeder
+	if s.BatchSize > 1 {
+		doc, ok, err := s.bulkItem(item.action)
 		if err != nil {
-			results = append(results, "Error: "+file)
-			_, f := filepath.Split(file)
+			r.finish(item, false, err)
+			return
+		}
+		if ok {
+			r.batchMu.Lock()
+			defer r.batchMu.Unlock()
+			if r.batch.full(s, len(doc)) {
+				r.flush()
+			}
+			r.batch.add(item, doc)
+			return
+		}
+	}
+	size := item.info.Size
+	if item.doc != nil {
+		size = int64(len(item.doc))
+	}
+	r.sends.run(func() {
+		_, err := s.execute(item.action, size)
+		r.finish(item, false, err)
+	})
+}
+
+func (r *seedRun) failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err != nil
+}
+
+// finish records the outcome of a seed file and then removes it or
+// moves it to the poison folder unless files are kept
+func (r *seedRun) finish(item seedItem, unchanged bool, err error) {
+	if item.doc != nil {
+		r.finishDocument(item, unchanged, err)
+		return
+	}
+	r.mu.Lock()
+	seeded := r.record(item, unchanged, err)
+	r.mu.Unlock()
+
+	if seeded {
+		r.queueRecord(item.info)
+	}
+}
+
+// record adds the outcome of a seed file to the results and report and
+// moves the file. Returns if the file was seeded
+func (r *seedRun) record(item seedItem, unchanged bool, err error) bool {
+	s, file := r.seeder, item.file
+	outcome := SeedOutcome{File: file, Seeded: err == nil && !unchanged, Unchanged: unchanged}
+	switch {
+	case err != nil:
+		outcome.Error = err.Error()
+		r.results = append(r.results, "Error: "+file+"\n"+err.Error())
+	case unchanged:
+		r.results = append(r.results, "Unchanged: "+file)
+	default:
+		r.results = append(r.results, "Success: "+file)
+		if item.data == nil {
+			r.report.Documents++ //documents of data files are counted as they are sent
+		}
+		r.report.Bytes += item.info.Size
+	}
+	r.report.Files = append(r.report.Files, outcome)
+
+	if s.Keep {
+		//files are left in place
+	} else if err != nil {
+		pd := getPoisonSubDir(r.poison, file)
+		_, f := filepath.Split(file)
 
-			if _, err := os.Stat(pd); os.IsNotExist(err) {
-				err := os.Mkdir(pd, 0777)
-				if err != nil {
-					log.Panic(err)
+		//the batch is created with the first failure
+		if _, err := os.Stat(pd); os.IsNotExist(err) {
+			err := os.MkdirAll(pd, 0777)
+			if err != nil {
+				if r.err == nil {
+					r.err = err
 				}
+				return false
 			}
+		}
 
-			err := os.Rename(file, filepath.Join(pd, f))
-			if err != nil {
-				results = append(results, "Error moving to poison folder: "+file+"\n"+err.Error())
+		var poisoned string
+		var err error
+		if item.data != nil && len(item.data.failed) > 0 {
+			//only the documents that failed are poison
+			poisoned, err = r.poisonDocuments(item, pd)
+			if err == nil {
+				err = os.Remove(file)
 			}
 		} else {
-			results = append(results, "Success: "+file)
-			err := os.Remove(file)
-			if err != nil {
-				results = append(results, "Error deleting file: "+file+"\n"+err.Error())
-			}
+			poisoned = filepath.Join(pd, f)
+			err = os.Rename(file, poisoned)
+		}
+		if err == nil {
+			//the error and the path of the file are kept next to it for seed replay
+			err = writePoisonError(poisoned, outcome.Error)
+		}
+		if err == nil {
+			err = writePoisonPath(poisoned, r.relPath(file))
+		}
+		if err != nil {
+			r.results = append(r.results, "Error moving to poison folder: "+file+"\n"+err.Error())
+		}
+	} else {
+		err := os.Remove(file)
+		if err != nil {
+			r.results = append(r.results, "Error deleting file: "+file+"\n"+err.Error())
 		}
 	}
-	return results, nil
-}
 
-// Apply will apply the schema change to Elastic Search
-func (s *Seeder) execute(a Action) error {
+	return outcome.Seeded
+}
 
-	u := a.URL
-	if !strings.HasPrefix(u, "/") {
-		u = "/" + u
+// InGitRepository determines if dir is within a git working tree, where
+// seed files are checked in and should be kept
+func InGitRepository(dir string) bool {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return false
 	}
-	url := fmt.Sprintf("%s%s", s.ServerURL, u)
-	var body io.Reader
-	if a.JSON != "" {
-		body = bytes.NewBuffer([]byte(a.JSON))
+	for {
+		if _, err := os.Stat(filepath.Join(abs, ".git")); err == nil {
+			return true
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return false
+		}
+		abs = parent
 	}
-	req, _ := http.NewRequest(a.HTTPVerb, url, body)
-	req.Header.Add("Accept", "application/json")
+}
 
-	if s.Creds.AuthorizationNeeded() {
-		req.SetBasicAuth(s.Creds.Username, s.Creds.Password)
-	}
+// execute sends the Action to Elastic Search and returns the response body.
+// Size is the number of bytes in the body for the rate limiter
+func (s *Seeder) execute(a Action, size int64) ([]byte, error) {
 
-	if body != nil {
-		req.Header.Add("Content-Type", "application/json")
-	}
+	url := joinURL(s.ServerURL, a.URL)
+	//the body is opened again for each attempt
+	resp, err := s.do(func() (*http.Request, error) {
+		body, err := a.body()
+		if err != nil {
+			return nil, err
+		}
+		if body != nil && s.Compress {
+			body = gzipBody(body)
+		}
+		req, err := http.NewRequest(a.HTTPVerb, url, body)
+		if err != nil {
+			if body != nil {
+				body.Close()
+			}
+			return nil, err
+		}
+		req.Header.Add("Accept", "application/json")
+
+		if s.Creds.AuthorizationNeeded() {
+			req.SetBasicAuth(s.Creds.Username, s.Creds.Password)
+		}
 
-	resp, err := s.HTTPClient.Do(req)
-	if err != nil || resp.StatusCode != 201 {
-		bodyBytes, err2 := ioutil.ReadAll(resp.Body)
-		if err2 != nil {
-			return err2
+		if body != nil {
+			req.Header.Add("Content-Type", a.ContentType())
+			if s.Compress {
+				req.Header.Add("Content-Encoding", "gzip")
+			}
 		}
-		bodyString := string(bodyBytes)
-		return errors.New(bodyString)
+		return req, nil
+	}, size)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
-	return nil
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !seedSuccess(a, resp.StatusCode) {
+		return bodyBytes, errors.New(string(bodyBytes))
+	}
+	return bodyBytes, nil
 }
 
-func (s *Seeder) getFiles(dir string) []string {
-	fileList := []string{}
-	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
-		if filepath.Ext(path) == ".js" {
-			fileList = append(fileList, path)
+func (r *seedRun) getFiles() ([]string, error) {
+	s := r.seeder
+	//never seed the files already moved to the poison folder, or the report
+	filter := s.Filter
+	filter.Exclude = append([]string{"/" + poisonDir + "/"}, filter.Exclude...)
+	report, _ := filepath.Abs(s.ReportFile)
+	return filter.walk(s.Directory, func(path string) bool {
+		if abs, _ := filepath.Abs(path); s.ReportFile != "" && abs == report {
+			return false
 		}
-		return nil
+		return filepath.Ext(path) == ".js" || r.isDataFile(path)
 	})
-	if err != nil {
-		log.Fatal(err)
+}
+
+// relPath is the path of a seed file relative to the seed folder, which
+// the .esdeployseed globs match and seed records are named after. A file
+// replayed from the poison folder keeps the path it was seeded from
+func (r *seedRun) relPath(file string) string {
+	if rel, ok := r.paths[file]; ok {
+		return rel
 	}
-	return fileList
+	rel, _ := filepath.Rel(r.seeder.Directory, file)
+	return filepath.ToSlash(rel)
 }
 
-func (s *Seeder) getAction(esFile string) Action {
+// getAction reads the url of a seed file, optionally after a verb (POST
+// cars/_doc) and a header of comment lines. The op header, otherwise op,
+// sets the operation
+//
+//	// op: upsert
+//	cars/_doc/1
+func (s *Seeder) getAction(esFile string, op string) (Action, error) {
 	file, err := os.Open(esFile)
 	if err != nil {
-		log.Fatal(err)
+		return Action{}, ErrScriptFile{File: esFile, Err: err}
 	}
 	defer file.Close()
 
-	//Grab the url (line 1) and the body...rest of document
-	scanner := bufio.NewScanner(file)
-	scanner.Scan()
-	url := scanner.Text()
-
-	var body bytes.Buffer
+	//Grab the url, the body is the rest of document which is
+	//streamed when sent so documents of any size can be seeded
+	scanner := newLineScanner(file)
+	header := make(map[string]string)
+	line := 0
 	for scanner.Scan() {
-		body.WriteString(scanner.Text())
+		line++
+		if !seedHeaderLine(scanner.Text(), header) {
+			break
+		}
 	}
-
 	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+		return Action{}, ErrScriptFile{File: esFile, Err: err}
+	}
+	a := Action{
+		HTTPVerb:   "PUT",
+		URL:        strings.TrimSpace(scanner.Text()),
+		bodyFile:   esFile,
+		bodyOffset: scanner.read,
 	}
-	return Action{
-		HTTPVerb: "PUT",
-		URL:      url,
-		JSON:     body.String(),
+	if fields := strings.Fields(a.URL); len(fields) == 2 && isStandardVerb(fields[0]) {
+		a.HTTPVerb, a.URL = fields[0], fields[1]
+	}
+	a.URL, err = s.template.replace(a.URL)
+	if err != nil {
+		return Action{}, ErrScriptFile{File: esFile, Line: line, Err: err}
+	}
+	a.tokens = s.template
+	if v, ok := header["op"]; ok {
+		op = v
+	}
+	a, err = seedOperation(op, a)
+	if err != nil {
+		return Action{}, ErrScriptFile{File: esFile, Line: line, Err: err}
 	}
+	return a, nil
 }
 
 func getPoisonSubDir(poisonDir, file string) string {
@@ -0,0 +1,392 @@
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedServer is a fake elastic search that creates every document except
+// those with a path in failures. It keeps the seed records and counts the
+// requests sent for each document, documents sent with _bulk are counted
+// under their index/_doc/id path along with the number of _bulk requests
+func seedServer(failures map[string]bool) (*httptest.Server, map[string]string, map[string]int) {
+	records := make(map[string]string)
+	docs := make(map[string]int)
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if seedRecords(w, r, records) {
+			docs[r.URL.Path]++
+			return
+		}
+		if r.URL.Path == "/_bulk" {
+			docs["/_bulk"]++
+			body, _ := ioutil.ReadAll(r.Body)
+			lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+			var items []string
+			for i := 0; i+1 < len(lines); i += 2 {
+				var meta struct {
+					Index struct {
+						Index string `json:"_index"`
+						ID    string `json:"_id"`
+					} `json:"index"`
+				}
+				json.Unmarshal([]byte(lines[i]), &meta)
+				path := "/" + meta.Index.Index + "/_doc/" + meta.Index.ID
+				docs[path]++
+				if failures[path] {
+					items = append(items, `{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}`)
+				} else {
+					items = append(items, `{"index":{"status":201,"result":"created"}}`)
+				}
+			}
+			w.Write([]byte(`{"errors":true,"items":[` + strings.Join(items, ",") + `]}`))
+			return
+		}
+		docs[r.URL.Path]++
+		if failures[r.URL.Path] {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":{"type":"mapper_parsing_exception"},"status":400}`))
+			return
+		}
+		w.WriteHeader(201)
+		w.Write([]byte(`{"result":"created"}`))
+	}))
+	return ts, records, docs
+}
+
+// seedRecords answers the _mget and _bulk requests for the records of
+// seeded files from records, keyed by ID. Returns false for other requests
+func seedRecords(w http.ResponseWriter, r *http.Request, records map[string]string) bool {
+	if !strings.HasPrefix(r.URL.Path, "/"+seedIndex+"/") {
+		return false
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	switch r.URL.Path {
+	case "/" + seedIndex + "/_mget":
+		var mget struct {
+			IDs []string `json:"ids"`
+		}
+		json.Unmarshal(body, &mget)
+		var docs []string
+		for _, id := range mget.IDs {
+			quoted, _ := json.Marshal(id)
+			if record, ok := records[id]; ok {
+				docs = append(docs, fmt.Sprintf(`{"_id":%s,"found":true,"_source":%s}`, quoted, record))
+			} else {
+				docs = append(docs, fmt.Sprintf(`{"_id":%s,"found":false}`, quoted))
+			}
+		}
+		w.Write([]byte(`{"docs":[` + strings.Join(docs, ",") + `]}`))
+	case "/" + seedIndex + "/_bulk":
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		var items []string
+		for i := 0; i+1 < len(lines); i += 2 {
+			var meta struct {
+				Index struct {
+					ID   string `json:"_id"`
+					Type string `json:"_type"`
+				} `json:"index"`
+			}
+			json.Unmarshal([]byte(lines[i]), &meta)
+			if meta.Index.Type != "" {
+				//rejected by elastic search 8
+				items = append(items, `{"index":{"status":400,"error":{"type":"illegal_argument_exception"}}}`)
+				continue
+			}
+			if records != nil {
+				records[meta.Index.ID] = lines[i+1]
+			}
+			items = append(items, `{"index":{"status":201}}`)
+		}
+		w.Write([]byte(`{"items":[` + strings.Join(items, ",") + `]}`))
+	default:
+		w.WriteHeader(404)
+	}
+	return true
+}
+
+func TestSeedKeepWritesReport(t *testing.T) {
+	ts, _, _ := seedServer(map[string]bool{"/cars/_doc/2": true})
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	one := writeScript(t, dir, "cars/1.js", "cars/_doc/1\n{}")
+	two := writeScript(t, dir, "cars/2.js", "cars/_doc/2\n{}")
+	report := filepath.Join(dir, "report.json")
+
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	assert.False(t, seeder.Keep)
+	seeder.Keep = true
+	seeder.ReportFile = report
+	results, err := seeder.Seed()
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, "Success: "+one, results[0])
+	assert.True(t, strings.HasPrefix(results[2], "Seeded 1 documents"), results[2])
+	assert.FileExists(t, one)
+	assert.FileExists(t, two)
+	_, err = os.Stat(filepath.Join(dir, poisonDir))
+	assert.True(t, os.IsNotExist(err))
+
+	b, err := ioutil.ReadFile(report)
+	require.NoError(t, err)
+	var r SeedReport
+	require.NoError(t, json.Unmarshal(b, &r))
+	require.Len(t, r.Files, 2)
+	assert.Equal(t, 1, r.Documents)
+	assert.True(t, r.Files[0].Seeded)
+	assert.False(t, r.Files[1].Seeded)
+	assert.Contains(t, r.Files[1].Error, "mapper_parsing_exception")
+}
+
+func TestSeedRemovesFiles(t *testing.T) {
+	ts, _, _ := seedServer(map[string]bool{"/cars/_doc/2": true})
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	one := writeScript(t, dir, "cars/1.js", "cars/_doc/1\n{}")
+	two := writeScript(t, dir, "cars/2.js", "cars/_doc/2\n{}")
+	_, err = NewSeeder(dir, ts.URL, Creds{}, false).Seed()
+	require.NoError(t, err)
+
+	_, err = os.Stat(one)
+	assert.True(t, os.IsNotExist(err))
+	poisoned, _ := filepath.Glob(filepath.Join(dir, poisonDir, "*", "cars", "2.js"))
+	assert.Len(t, poisoned, 1)
+	_, err = os.Stat(two)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInGitRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "data", "cars"), 0777))
+	assert.False(t, InGitRepository(filepath.Join(dir, "data", "cars")))
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, ".git"), 0777))
+	assert.True(t, InGitRepository(filepath.Join(dir, "data", "cars")))
+	assert.True(t, NewSeeder(filepath.Join(dir, "data"), "", Creds{}, false).Keep)
+}
+
+func TestSeedOnlyNewOrChanged(t *testing.T) {
+	ts, records, docs := seedServer(nil)
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	one := writeScript(t, dir, "cars/1.js", "cars/_doc/1\n{}")
+	two := writeScript(t, dir, "cars/2.js", "cars/_doc/2\n{}")
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	seeder.Keep = true
+	_, err = seeder.Seed()
+	require.NoError(t, err)
+	assert.Contains(t, records["cars/1.js"], `"url":"cars/_doc/1"`)
+
+	writeScript(t, dir, "cars/2.js", "cars/_doc/2\n{ \"make\": \"Ford\" }")
+	results, err := seeder.Seed()
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, []string{"Unchanged: " + one, "Success: " + two}, results[:2])
+	assert.Equal(t, 1, docs["/cars/_doc/1"])
+	assert.Equal(t, 2, docs["/cars/_doc/2"])
+
+	seeder.Force = true
+	_, err = seeder.Seed()
+	require.NoError(t, err)
+	assert.Equal(t, 2, docs["/cars/_doc/1"])
+}
+
+func TestSeedBulk(t *testing.T) {
+	ts, records, docs := seedServer(map[string]bool{"/cars/_doc/3": true})
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		files = append(files, writeScript(t, dir, "cars/"+id+".js", "cars/_doc/"+id+"\n{\n  \"id\": "+id+"\n}"))
+	}
+	writeScript(t, dir, "cars/search.js", "cars/_search\n{}")
+
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	seeder.BatchSize = 2
+	results, err := seeder.Seed()
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, docs["/_bulk"])
+	assert.Equal(t, 1, docs["/cars/_search"])
+	for i, file := range files {
+		assert.Equal(t, 1, docs["/cars/_doc/"+string('1'+rune(i))])
+		_, err = os.Stat(file)
+		assert.True(t, os.IsNotExist(err))
+	}
+	assert.Contains(t, results, "Success: "+files[0])
+	assert.Contains(t, results, "Error: "+files[2]+"\n400 {\"type\":\"mapper_parsing_exception\"}")
+	poisoned, _ := filepath.Glob(filepath.Join(dir, poisonDir, "*", "cars", "3.js"))
+	assert.Len(t, poisoned, 1)
+	assert.Contains(t, records, "cars/1.js")
+	assert.NotContains(t, records, "cars/3.js")
+	assert.True(t, strings.HasPrefix(results[len(results)-1], "Seeded 5 documents"), results[len(results)-1])
+	//the records are looked up and written a batch at a time
+	assert.Equal(t, 3, docs["/"+seedIndex+"/_mget"])
+	assert.Equal(t, 3, docs["/"+seedIndex+"/_bulk"])
+}
+
+func TestBulkBatchLimits(t *testing.T) {
+	seeder := &Seeder{BatchSize: 3, BatchBytes: 100}
+	doc, ok, err := seeder.bulkItem(Action{HTTPVerb: "PUT", URL: "cars/_doc/a%2Fb", JSON: "{ \"make\":\n \"Ford\" }"})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "{\"index\":{\"_id\":\"a/b\",\"_index\":\"cars\"}}\n{\"make\":\"Ford\"}\n", string(doc))
+
+	_, ok, err = seeder.bulkItem(Action{HTTPVerb: "PUT", URL: "cars/_doc/1", JSON: `{"name":"` + strings.Repeat("y", 100) + `"}`})
+	require.NoError(t, err)
+	assert.False(t, ok, "larger than BatchBytes")
+
+	b := new(bulkBatch)
+	assert.False(t, b.full(seeder, 200), "an empty batch takes any document")
+	b.add(seedItem{}, make([]byte, 60))
+	assert.True(t, b.full(seeder, 60))
+	assert.False(t, b.full(seeder, 40))
+	b.add(seedItem{}, make([]byte, 10))
+	b.add(seedItem{}, make([]byte, 10))
+	assert.True(t, b.full(seeder, 1))
+
+	for url, want := range map[string]bool{
+		"cars/_doc/1":    true,
+		"cars/car/1":     true,
+		"cars/_doc/1?op": false,
+		"cars/_update/1": false,
+		"cars/_search":   false,
+		"_bulk":          false,
+	} {
+		_, ok := bulkMeta(Action{HTTPVerb: "PUT", URL: url})
+		assert.Equal(t, want, ok, url)
+	}
+}
+
+func TestSeedSecuredCluster(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "elastic" || pass != "secret" {
+			w.WriteHeader(401)
+			return
+		}
+		if seedRecords(w, r, nil) {
+			return
+		}
+		if r.URL.Path == "/cars/_doc/slow" {
+			mu.Lock()
+			attempts++
+			mu.Unlock()
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(201)
+	}))
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0) //the failed handshake is expected
+	ts.StartTLS()
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := writeScript(t, dir, "cars/1.js", "cars/_doc/1\n{}")
+	creds := Creds{Username: "elastic", Password: "secret"}
+
+	//the test server has a self signed certificate
+	seeder := NewSeeder(dir, ts.URL, creds, false)
+	seeder.Keep = true
+	seeder.Retries = 1
+	results, err := seeder.Seed()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(results[0], "Error: "+file+"\n"), results[0])
+	assert.Contains(t, results[0], "certificate")
+
+	seeder = NewSeeder(dir, ts.URL, creds, true)
+	seeder.Keep = true
+	assert.Equal(t, ts.URL+"/", seeder.ServerURL)
+	results, err = seeder.Seed()
+	require.NoError(t, err)
+	assert.Equal(t, "Success: "+file, results[0])
+
+	//requests that time out are not sent again as they may have been applied
+	slow := writeScript(t, dir, "cars/slow.js", "cars/_doc/slow\n{}")
+	seeder.Timeout = 50 * time.Millisecond
+	seeder.Backoff = time.Millisecond
+	seeder.Retries = 2
+	results, err = seeder.Seed()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(results[1], "Error: "+slow+"\n"), results[1])
+	assert.Contains(t, results[1], "deadline exceeded")
+	mu.Lock()
+	assert.Equal(t, 1, attempts)
+	mu.Unlock()
+}
+
+func TestSeedRetriesOnlyFailedConnections(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.WriteHeader(201)
+	}))
+	defer ts.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	seeder.Backoff = time.Millisecond
+	seeder.Retries = 3
+	send := func(url string, body io.Reader) (int, error) {
+		attempts := 0
+		_, err := seeder.do(func() (*http.Request, error) {
+			attempts++
+			return http.NewRequest("POST", url, body)
+		}, 0)
+		return attempts, err
+	}
+
+	//nothing was sent
+	attempts, err := send(closed.URL+"/cars/_doc", nil)
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+
+	//the server is down for the rest of the run, the next run tries again
+	attempts, err = send(closed.URL+"/cars/_doc", nil)
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+	_, err = seeder.Seed()
+	require.NoError(t, err)
+	attempts, err = send(closed.URL+"/cars/_doc", nil)
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+
+	//the body failed after the request was sent
+	attempts, err = send(ts.URL+"/cars/_doc", newSeedTemplate(nil, time.Now()).reader(strings.NewReader("{{env.ESDEPLOY_MISSING}}")))
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,173 @@
+package elastic
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiter spaces out requests so no more than a number of requests
+// or bytes are sent each second. A nil rateLimiter does not wait
+type rateLimiter struct {
+	requests float64 //per second, 0 for no limit
+	bytes    int64   //per second, 0 for no limit
+	mu       sync.Mutex
+	next     time.Time //when the next request may start
+}
+
+func newRateLimiter(requests float64, bytes int64) *rateLimiter {
+	if requests <= 0 && bytes <= 0 {
+		return nil
+	}
+	return &rateLimiter{requests: requests, bytes: bytes}
+}
+
+// wait blocks until a request of size bytes may be sent
+func (l *rateLimiter) wait(size int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	start := l.next
+	var cost time.Duration
+	if l.requests > 0 {
+		cost = time.Duration(float64(time.Second) / l.requests)
+	}
+	if l.bytes > 0 {
+		if c := time.Duration(float64(size) / float64(l.bytes) * float64(time.Second)); c > cost {
+			cost = c
+		}
+	}
+	l.next = start.Add(cost)
+	l.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+// workerPool runs jobs on a number of goroutines. With a single worker
+// each job runs on the calling goroutine so the order is kept
+type workerPool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+}
+
+func newWorkerPool(workers int) *workerPool {
+	p := &workerPool{}
+	if workers <= 1 {
+		return p
+	}
+	p.jobs = make(chan func())
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+	return p
+}
+
+// run waits for a free worker to run the job
+func (p *workerPool) run(job func()) {
+	if p.jobs == nil {
+		job()
+		return
+	}
+	p.jobs <- job
+}
+
+// wait blocks until every job has finished, the pool can not be used afterwards
+func (p *workerPool) wait() {
+	if p.jobs != nil {
+		close(p.jobs)
+		p.wg.Wait()
+	}
+}
+
+// do sends a request built by req, waiting for the rate limiter first. When
+// elastic search rejects the request with 429 Too Many Requests, because its
+// queues are full (es_rejected_execution_exception), or is unavailable it is
+// sent again after waiting Backoff, which doubles each attempt, or as long as
+// the server asks. A request that fails to connect is also sent again, but
+// not one that fails or times out once it is sent, as elastic search may
+// have indexed the document and sending it again could add it twice or fail
+// to create it. Once a request can not connect after every attempt the
+// server is taken to be down, so the other requests of the run are not
+// sent again
+func (s *Seeder) do(req func() (*http.Request, error), size int64) (*http.Response, error) {
+	wait := s.Backoff
+	for attempt := 1; ; attempt++ {
+		s.limiter.wait(size)
+		r, err := req()
+		if err != nil {
+			return nil, err
+		}
+		cancel := func() {}
+		if s.Timeout > 0 {
+			var ctx context.Context
+			ctx, cancel = context.WithTimeout(r.Context(), s.Timeout)
+			r = r.WithContext(ctx)
+		}
+		resp, err := s.HTTPClient.Do(r)
+		down := err != nil && notConnected(err)
+		if down && (attempt >= s.Retries || atomic.LoadInt32(&s.unreachable) == 1) {
+			atomic.StoreInt32(&s.unreachable, 1)
+			cancel()
+			return nil, err
+		}
+		retry := down || (err == nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable))
+		if !retry || attempt >= s.Retries {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			resp.Body = cancelBody{resp.Body, cancel} //the timeout covers reading the response
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			wait = retryAfter(resp, wait)
+		}
+		cancel()
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// notConnected is true when a request failed before anything was sent
+// because the connection could not be made
+func notConnected(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// cancelBody releases the timeout of a request once its response is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// retryAfter is how long the Retry-After header of a response asks to
+// wait, or wait when it is not set
+func retryAfter(resp *http.Response, wait time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return wait
+}
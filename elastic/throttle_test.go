@@ -0,0 +1,136 @@
+package elastic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var none *rateLimiter
+	assert.Nil(t, newRateLimiter(0, 0))
+	none.wait(100) //does not block
+
+	l := newRateLimiter(100, 0)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.wait(0)
+	}
+	assert.True(t, time.Since(start) >= 40*time.Millisecond, "5 requests at 100/s")
+
+	l = newRateLimiter(1000, 1000)
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		l.wait(50)
+	}
+	assert.True(t, time.Since(start) >= 100*time.Millisecond, "150 bytes at 1000/s")
+}
+
+func TestSeedWorkers(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, most, bulks := 0, 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if seedRecords(w, r, nil) {
+			return
+		}
+		mu.Lock()
+		inFlight++
+		bulks++
+		if inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		items := strings.Repeat(`{"index":{"status":201}},`, strings.Count(string(body), "\n")/2)
+		w.Write([]byte(`{"items":[` + strings.TrimSuffix(items, ",") + `]}`))
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 20; i++ {
+		writeScript(t, dir, fmt.Sprintf("cars/%02d.js", i), fmt.Sprintf("cars/_doc/%d\n{}", i))
+	}
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	seeder.Workers = 4
+	seeder.BatchSize = 2
+	results, err := seeder.Seed()
+	require.NoError(t, err)
+
+	require.Len(t, results, 21)
+	for _, result := range results[:20] {
+		assert.True(t, strings.HasPrefix(result, "Success: "), result)
+	}
+	assert.Equal(t, 10, bulks)
+	assert.True(t, most > 1, "batches are sent at the same time")
+	assert.True(t, most <= 4, "no more than 4 workers")
+}
+
+func TestSeedBacksOffWhenRejected(t *testing.T) {
+	rejected := `{"error":{"type":"es_rejected_execution_exception"},"status":429}`
+	attempts := make(map[string]int)
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if seedRecords(w, r, nil) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[r.URL.Path]++
+		switch {
+		case r.URL.Path == "/_bulk":
+			//the second document is rejected the first time and sent again on its own
+			if attempts["/_bulk"] == 1 {
+				w.Write([]byte(`{"items":[{"index":{"status":201}},{"index":{"status":429}}]}`))
+			} else {
+				w.Write([]byte(`{"items":[{"index":{"status":201}}]}`))
+			}
+		case attempts[r.URL.Path] < 3:
+			w.WriteHeader(429)
+			w.Write([]byte(rejected))
+		default:
+			w.WriteHeader(201)
+		}
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	search := writeScript(t, dir, "cars/search.js", "cars/_search\n{}")
+	writeScript(t, dir, "trucks/1.js", "trucks/_doc/1\n{}")
+	two := writeScript(t, dir, "trucks/2.js", "trucks/_doc/2\n{}")
+	seeder := NewSeeder(dir, ts.URL, Creds{}, false)
+	seeder.Backoff = time.Millisecond
+	seeder.BatchSize = 2
+	results, err := seeder.Seed()
+	require.NoError(t, err)
+	assert.Equal(t, "Success: "+search, results[0])
+	assert.Equal(t, 3, attempts["/cars/_search"])
+	assert.Equal(t, 2, attempts["/_bulk"])
+	assert.Contains(t, results, "Success: "+two)
+
+	//gives up after Retries
+	attempts = make(map[string]int)
+	writeScript(t, dir, "cars/search.js", "cars/_search\n{}")
+	seeder.Retries = 2
+	results, err = seeder.Seed()
+	require.NoError(t, err)
+	assert.Equal(t, "Error: "+search+"\n"+rejected, results[0])
+	assert.Equal(t, 2, attempts["/cars/_search"])
+}
@@ -0,0 +1,186 @@
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Severity is how serious a validation diagnostic is
+type Severity string
+
+const (
+	// SeverityError means the schema file can not be applied
+	SeverityError Severity = "error"
+	// SeverityWarning means the schema file can be applied but is suspicious
+	SeverityWarning Severity = "warning"
+)
+
+// Rule IDs identifying which check produced a diagnostic
+const (
+	RuleUnreadable        = "unreadable"
+	RuleBadHeader         = "bad-header"
+	RuleUnknownHeader     = "unknown-header"
+	RuleUnknownDependency = "unknown-dependency"
+	RuleBadVerb           = "bad-verb"
+	RuleCustomVerb        = "custom-verb"
+	RuleEmptyURL          = "empty-url"
+	RuleBadRetry          = "bad-retry"
+	RuleBadJSON           = "bad-json"
+	RuleBadYAML           = "bad-yaml"
+	RuleUnknownToken      = "unknown-token"
+	RuleBadAssertion      = "bad-assertion"
+	RuleBadPrecondition   = "bad-precondition"
+	RuleDuplicateID       = "duplicate-id"
+)
+
+// Diagnostic describes a single problem found while validating a schema file
+type Diagnostic struct {
+	Severity Severity
+	Rule     string
+	Line     int //Line number in the schema file, 0 when not tied to a line
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	if d.Line > 0 {
+		return fmt.Sprintf("line %d: %s [%s] %s", d.Line, d.Severity, d.Rule, d.Message)
+	}
+	return fmt.Sprintf("%s [%s] %s", d.Severity, d.Rule, d.Message)
+}
+
+// ValidationResult is the result of validating a schema file
+type ValidationResult struct {
+	File        string
+	IsValid     bool
+	Diagnostics []Diagnostic
+}
+
+// knownTokens are the tokens replaced when a schema file is deployed
+var knownTokens = map[string]string{
+	"shards":   "5",
+	"replicas": "1",
+}
+
+var tokenPattern = regexp.MustCompile(`{{\s*([^{}]*?)\s*}}`)
+
+// hasErrors determines if any of the diagnostics is an error
+func hasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// diagnose runs every validation rule against the raw script
+func diagnose(sc *script) []Diagnostic {
+	var diags []Diagnostic
+	errorf := func(rule string, line int, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{SeverityError, rule, line, fmt.Sprintf(format, args...)})
+	}
+
+	if h, line, err := parseHeader(sc.Header, sc.HeaderLine); err != nil {
+		errorf(RuleBadHeader, line, "%v", err)
+	} else {
+		for _, key := range h.unknown {
+			diags = append(diags, Diagnostic{SeverityWarning, RuleUnknownHeader, h.lines[key],
+				fmt.Sprintf("unknown header %q is ignored", key)})
+		}
+	}
+
+	if len(sc.Steps) == 0 {
+		errorf(RuleBadVerb, sc.HeaderLine+len(sc.Header), "no request found, expecting an HTTP verb")
+	}
+	for _, step := range sc.Steps {
+		diags = append(diags, diagnoseStep(step)...)
+	}
+
+	if sc.WhenLine > 0 {
+		if _, err := parsePrecondition(stripComments(strings.Join(sc.When, "\n"))); err != nil {
+			errorf(RuleBadPrecondition, sc.WhenLine, "%v", err)
+		}
+	}
+	return diags
+}
+
+// diagnoseStep runs the validation rules for a single request
+func diagnoseStep(step *scriptStep) []Diagnostic {
+	var diags []Diagnostic
+	errorf := func(rule string, line int, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{SeverityError, rule, line, fmt.Sprintf(format, args...)})
+	}
+
+	if !isVerb(step.Verb) {
+		errorf(RuleBadVerb, step.VerbLine, "invalid HTTP verb %q, expecting an uppercase method such as %s", step.Verb, strings.Join(verbs[:], ", "))
+	} else if !isStandardVerb(step.Verb) {
+		diags = append(diags, Diagnostic{SeverityWarning, RuleCustomVerb, step.VerbLine,
+			fmt.Sprintf("HTTP verb %q is not a standard method", step.Verb)})
+	}
+
+	if strings.TrimSpace(step.URL) == "" {
+		errorf(RuleEmptyURL, step.URLLine, "URL is empty")
+	} else if _, _, err := parseURL(step.URL); err != nil {
+		errorf(RuleBadRetry, step.URLLine, "retry option must be a number: %v", err)
+	}
+
+	//comments are blanked out first so line numbers still match the file
+	raw, err := step.bodyLines()
+	if err != nil {
+		errorf(RuleUnreadable, step.BodyLine, "%v", err)
+	}
+	lines := strings.Split(stripComments(strings.Join(raw, "\n")), "\n")
+	body := make([]string, len(lines))
+	for i, line := range lines {
+		body[i] = tokenPattern.ReplaceAllStringFunc(line, func(t string) string {
+			name := tokenPattern.FindStringSubmatch(t)[1]
+			if v, ok := knownTokens[name]; ok {
+				return v
+			}
+			diags = append(diags, Diagnostic{SeverityWarning, RuleUnknownToken, step.BodyLine + i,
+				fmt.Sprintf("unknown token %s will be sent as is", t)})
+			return t
+		})
+	}
+
+	text := strings.Join(body, "\n")
+	var js map[string]interface{}
+	if strings.TrimSpace(text) == "" {
+		//empty bodies are allowed
+	} else if isNDJSONURL(step.URL) {
+		if line, err := ndjsonValid(text); err != nil {
+			errorf(RuleBadJSON, step.BodyLine+line-1, "%v", err)
+		}
+	} else if err := json.Unmarshal([]byte(text), &js); err != nil {
+		line, col := step.BodyLine, 0
+		if se, ok := err.(*json.SyntaxError); ok {
+			line, col = offsetPosition(text, se.Offset)
+			line += step.BodyLine - 1
+		}
+		if col > 0 {
+			errorf(RuleBadJSON, line, "%v (column %d)", err, col)
+		} else {
+			errorf(RuleBadJSON, line, "%v", err)
+		}
+	}
+
+	if step.AssertLine > 0 {
+		if _, err := parseAssertion(stripComments(strings.Join(step.Assert, "\n"))); err != nil {
+			errorf(RuleBadAssertion, step.AssertLine, "%v", err)
+		}
+	}
+	return diags
+}
+
+// offsetPosition converts a byte offset within text to a 1 based line and column
+func offsetPosition(text string, offset int64) (int, int) {
+	if offset > int64(len(text)) {
+		offset = int64(len(text))
+	}
+	before := text[:offset]
+	line := strings.Count(before, "\n") + 1
+	col := len(before) - strings.LastIndex(before, "\n")
+	return line, col
+}
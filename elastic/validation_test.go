@@ -0,0 +1,108 @@
+package elastic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeScript(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0777))
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0666))
+	return file
+}
+
+func diagnoseContent(t *testing.T, content string) []Diagnostic {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sc, err := readScript(writeScript(t, dir, "test/01.js", content))
+	require.NoError(t, err)
+	return diagnose(sc)
+}
+
+func TestDiagnoseValidScript(t *testing.T) {
+	diags := diagnoseContent(t, "PUT\nfoo\n{\n  \"settings\": { \"index.number_of_shards\": {{shards}} }\n}")
+	assert.Empty(t, diags)
+}
+
+func TestDiagnoseBadVerbAndEmptyURL(t *testing.T) {
+	diags := diagnoseContent(t, "put\n\n{}")
+	require.Len(t, diags, 2)
+	assert.Equal(t, RuleBadVerb, diags[0].Rule)
+	assert.Equal(t, 1, diags[0].Line)
+	assert.Contains(t, diags[0].Message, `invalid HTTP verb "put", expecting an uppercase method such as POST, PUT`)
+	assert.Equal(t, RuleEmptyURL, diags[1].Rule)
+	assert.Equal(t, 2, diags[1].Line)
+}
+
+func TestDiagnoseCustomVerbAndEmptyBody(t *testing.T) {
+	diags := diagnoseContent(t, "PURGE\nfoo/_cache")
+	require.Len(t, diags, 1)
+	assert.Equal(t, RuleCustomVerb, diags[0].Rule)
+	assert.Equal(t, SeverityWarning, diags[0].Severity)
+}
+
+func TestDiagnoseBadAssertion(t *testing.T) {
+	diags := diagnoseContent(t, "GET\nfoo/_count\nASSERT\n{ \"exists\": [\"count\"] }")
+	require.Len(t, diags, 1)
+	assert.Equal(t, RuleBadAssertion, diags[0].Rule)
+	assert.Equal(t, 4, diags[0].Line)
+}
+
+func TestDiagnoseBadRetry(t *testing.T) {
+	diags := diagnoseContent(t, "POST\nfoo/_update_by_query?retry=x\n{}")
+	require.Len(t, diags, 1)
+	assert.Equal(t, RuleBadRetry, diags[0].Rule)
+	assert.Equal(t, 2, diags[0].Line)
+}
+
+func TestDiagnoseBadJSONLine(t *testing.T) {
+	diags := diagnoseContent(t, "PUT\nfoo\n{\n  \"a\": 1,\n  \"b\": ,\n}")
+	require.Len(t, diags, 1)
+	assert.Equal(t, RuleBadJSON, diags[0].Rule)
+	assert.Equal(t, SeverityError, diags[0].Severity)
+	assert.Equal(t, 5, diags[0].Line)
+}
+
+func TestDiagnoseUnknownToken(t *testing.T) {
+	diags := diagnoseContent(t, "PUT\nfoo\n{\n  \"a\": \"{{tenant}}\"\n}")
+	require.Len(t, diags, 1)
+	assert.Equal(t, RuleUnknownToken, diags[0].Rule)
+	assert.Equal(t, SeverityWarning, diags[0].Severity)
+	assert.Equal(t, 4, diags[0].Line)
+}
+
+func TestDiagnoseMultipleRequests(t *testing.T) {
+	diags := diagnoseContent(t, "PUT\ncars\n{}\n\nPOST cars/_refresh\n\nPURGE cars/_doc/1\n{ bad }")
+	require.Len(t, diags, 2)
+	assert.Equal(t, RuleCustomVerb, diags[0].Rule)
+	assert.Equal(t, 7, diags[0].Line)
+	assert.Equal(t, RuleBadJSON, diags[1].Rule)
+	assert.Equal(t, 8, diags[1].Line)
+}
+
+func TestValidateDuplicateIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	//folders with the same name in different places no longer collide
+	writeScript(t, dir, "a/common/01.js", "PUT\nfoo\n{}")
+	writeScript(t, dir, "b/common/01.js", "PUT\nbar\n{}")
+	//IDs that only differ by case collide on case insensitive file systems
+	writeScript(t, dir, "b/Common/01.js", "PUT\nbar\n{}")
+
+	results, err := NewRunner(dir, nil).Validate()
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.True(t, results[0].IsValid)
+	assert.True(t, results[1].IsValid)
+	assert.False(t, results[2].IsValid)
+	assert.Equal(t, RuleDuplicateID, results[2].Diagnostics[0].Rule)
+}
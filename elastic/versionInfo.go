@@ -4,10 +4,22 @@ import (
 	"time"
 )
 
+// VersionInfo is the record stored in elastic search for each applied schema change
 type VersionInfo struct {
 	ID         string    `json:"id"`
 	Folder     string    `json:"folder"`
 	File       string    `json:"file"`
 	Machine    string    `json:"machine"`
 	DateRunUtc time.Time `json:"dateRunUtc"`
+	RunMode    string    `json:"runMode,omitempty"` //once, onchange or always. Empty for records written before run modes
+	Hash       string    `json:"hash,omitempty"`    //sha256 of the schema file when it was applied
+	Partial    bool      `json:"partial,omitempty"` //Only the first Steps were applied, the rest are applied on the next deployment
+	Steps      []string  `json:"steps,omitempty"`   //sha256 of each step applied while Partial
+
+	//From the header of the schema file
+	Description string   `json:"description,omitempty"`
+	Author      string   `json:"author,omitempty"`
+	Ticket      string   `json:"ticket,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	DependsOn   []string `json:"dependsOn,omitempty"`
 }
@@ -0,0 +1,299 @@
+package elastic
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlExtensions are the extensions of schema files written in YAML
+var yamlExtensions = []string{".yaml", ".yml"}
+
+// yamlStepKeys are the keys of a single request in a YAML schema file
+var yamlStepKeys = map[string]bool{"verb": true, "url": true, "body": true, "assert": true}
+
+var yamlLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// tokenPlaceholder replaces {{tokens}} while the YAML is parsed since
+// an unquoted {{shards}} is not valid YAML
+const tokenPlaceholder = "__esdeploy_token_%d__"
+
+var placeholderPattern = regexp.MustCompile(`__esdeploy_token_(\d+)__`)
+
+// readYAMLScript will read a YAML schema file into the same parts as a
+// .js schema file. Header options, the request (verb, url, body, assert) or
+// list of requests and the precondition (when) are keys of the document.
+// Bodies, assertions and preconditions are converted to JSON on a single line
+// and line numbers point at the YAML key so validation reports them the same way
+//
+//	description: Create the cars index
+//	retries: 3
+//	verb: PUT
+//	url: cars_v1
+//	body:
+//	  settings:
+//	    index.number_of_shards: {{shards}}
+func readYAMLScript(esFile string) (*script, error) {
+	content, err := ioutil.ReadFile(esFile)
+	if err != nil {
+		return nil, ErrScriptFile{File: esFile, Err: err}
+	}
+	hash := sha256.Sum256(content)
+
+	//swap tokens for placeholders, they are put back when converting to JSON
+	var tokens []string
+	text := tokenPattern.ReplaceAllStringFunc(string(content), func(t string) string {
+		tokens = append(tokens, t)
+		return fmt.Sprintf(tokenPlaceholder, len(tokens)-1)
+	})
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, yamlError(esFile, 0, err)
+	}
+	sc := &script{
+		HeaderLine: 1,
+		Header:     make([]string, strings.Count(text, "\n")+1),
+		Hash:       hex.EncodeToString(hash[:]),
+	}
+	if len(doc.Content) == 0 {
+		return sc, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, yamlError(esFile, root.Line, fmt.Errorf("expecting a mapping of options and requests"))
+	}
+
+	c := yamlConverter{tokens: tokens, lists: make(map[*scriptStep]bool)}
+	var single *scriptStep
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch {
+		case yamlStepKeys[key.Value]:
+			if single == nil {
+				single = &scriptStep{VerbLine: key.Line, URLLine: key.Line, BodyLine: key.Line}
+				sc.Steps = append(sc.Steps, single)
+			}
+			if err := c.setStepValue(single, key, value); err != nil {
+				return nil, yamlError(esFile, value.Line, err)
+			}
+		case key.Value == "requests":
+			if value.Kind != yaml.SequenceNode {
+				return nil, yamlError(esFile, value.Line, fmt.Errorf("requests must be a list"))
+			}
+			for _, item := range value.Content {
+				step, err := c.step(item)
+				if err != nil {
+					return nil, yamlError(esFile, item.Line, err)
+				}
+				sc.Steps = append(sc.Steps, step)
+			}
+		case key.Value == "when":
+			when, err := c.toJSON(value)
+			if err != nil {
+				return nil, yamlError(esFile, value.Line, err)
+			}
+			sc.When, sc.WhenLine = []string{when}, value.Line
+		default:
+			//everything else is a header option, placed on the line of its key
+			v, err := c.headerValue(value)
+			if err != nil {
+				return nil, yamlError(esFile, value.Line, err)
+			}
+			sc.Header[key.Line-1] = fmt.Sprintf("%s %s: %s", headerPrefix, key.Value, v)
+		}
+	}
+	c.joinLists(sc)
+	return sc, nil
+}
+
+// isYAMLFile determines if path is a YAML schema file based on its extension
+func isYAMLFile(path string) bool {
+	for _, e := range yamlExtensions {
+		if strings.HasSuffix(path, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// yamlError wraps a YAML problem with ErrBadYAML. The line number is
+// taken from the yaml error message when not known
+func yamlError(esFile string, line int, err error) error {
+	if m := yamlLinePattern.FindStringSubmatch(err.Error()); line == 0 && m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	return ErrScriptFile{File: esFile, Line: line, Err: fmt.Errorf("%w: %v", ErrBadYAML, err)}
+}
+
+// yamlConverter converts YAML nodes to the JSON sent to elastic search
+type yamlConverter struct {
+	tokens []string             //original text of each token placeholder
+	lists  map[*scriptStep]bool //steps with a list body
+}
+
+// step converts one item of the requests list
+func (c yamlConverter) step(n *yaml.Node) (*scriptStep, error) {
+	if n.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("each request must be a mapping with verb, url and body")
+	}
+	step := &scriptStep{VerbLine: n.Line, URLLine: n.Line, BodyLine: n.Line}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if !yamlStepKeys[key.Value] {
+			return nil, fmt.Errorf("unknown request key %q", key.Value)
+		}
+		if err := c.setStepValue(step, key, value); err != nil {
+			return nil, err
+		}
+	}
+	return step, nil
+}
+
+func (c yamlConverter) setStepValue(step *scriptStep, key, value *yaml.Node) error {
+	switch key.Value {
+	case "verb", "url":
+		if value.Kind != yaml.ScalarNode {
+			return fmt.Errorf("%s must be a single value", key.Value)
+		}
+		if key.Value == "verb" {
+			step.Verb, step.VerbLine = value.Value, value.Line
+		} else {
+			step.URL, step.URLLine = c.restoreTokens(value.Value), value.Line
+		}
+	case "body":
+		step.Body, step.BodyLine = nil, value.Line
+		if value.Kind == yaml.SequenceNode {
+			//a list is one JSON line per item for _bulk and _msearch, see joinLists
+			c.lists[step] = true
+			for _, item := range value.Content {
+				line, err := c.toJSON(item)
+				if err != nil {
+					return err
+				}
+				step.Body = append(step.Body, line)
+			}
+			return nil
+		}
+		body, err := c.toJSON(value)
+		if err != nil {
+			return err
+		}
+		step.Body = []string{body}
+	case "assert":
+		assert, err := c.toJSON(value)
+		if err != nil {
+			return err
+		}
+		step.Assert, step.AssertLine = []string{assert}, value.Line
+	}
+	return nil
+}
+
+// joinLists turns list bodies back into a JSON array unless the url takes
+// newline delimited JSON. The url is only known once the whole file is read
+func (c yamlConverter) joinLists(sc *script) {
+	for _, step := range sc.Steps {
+		if c.lists[step] && !isNDJSONURL(step.URL) {
+			step.Body = []string{"[" + strings.Join(step.Body, ",") + "]"}
+		}
+	}
+}
+
+// headerValue converts a header option to its text, lists are comma separated
+func (c yamlConverter) headerValue(n *yaml.Node) (string, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return c.restoreTokens(n.Value), nil
+	case yaml.SequenceNode:
+		var values []string
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("list items must be single values")
+			}
+			values = append(values, c.restoreTokens(item.Value))
+		}
+		return strings.Join(values, ", "), nil
+	}
+	return "", fmt.Errorf("option must be a value or a list")
+}
+
+// toJSON converts a YAML node to JSON keeping the order of the keys
+func (c yamlConverter) toJSON(n *yaml.Node) (string, error) {
+	var buf bytes.Buffer
+	if err := c.writeJSON(&buf, n); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c yamlConverter) writeJSON(buf *bytes.Buffer, n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		return c.writeJSON(buf, n.Content[0])
+	case yaml.AliasNode:
+		return c.writeJSON(buf, n.Alias)
+	case yaml.MappingNode:
+		buf.WriteString("{")
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			key, _ := json.Marshal(c.restoreTokens(n.Content[i].Value))
+			buf.Write(key)
+			buf.WriteString(":")
+			if err := c.writeJSON(buf, n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("}")
+	case yaml.SequenceNode:
+		buf.WriteString("[")
+		for i, item := range n.Content {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			if err := c.writeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]")
+	case yaml.ScalarNode:
+		//an unquoted token on its own is sent unquoted like in .js files ({{shards}})
+		if m := placeholderPattern.FindStringSubmatch(n.Value); m != nil && m[0] == n.Value && n.Style == 0 {
+			buf.WriteString(c.restoreTokens(n.Value))
+			return nil
+		}
+		if n.Tag == "!!str" || n.Tag == "!!binary" {
+			s, _ := json.Marshal(c.restoreTokens(n.Value))
+			buf.Write(s)
+			return nil
+		}
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return err
+		}
+		s, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(s)
+	}
+	return nil
+}
+
+// restoreTokens puts the original {{tokens}} back in place of the placeholders
+func (c yamlConverter) restoreTokens(text string) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(p string) string {
+		i, _ := strconv.Atoi(placeholderPattern.FindStringSubmatch(p)[1])
+		return c.tokens[i]
+	})
+}
@@ -0,0 +1,93 @@
+package elastic
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestYAMLScript(t *testing.T) {
+	sc, err := NewSchemaChange("../tests", "../tests/index_template.yaml", 2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "Create the foo template", sc.Header.Description)
+	assert.Equal(t, []string{"foo", "templates"}, sc.Header.Tags)
+	require.Len(t, sc.Actions, 1)
+	assert.Equal(t, "PUT", sc.Actions[0].HTTPVerb)
+	assert.Equal(t, "_index_template/foo_template", sc.Actions[0].URL)
+	assert.NoError(t, sc.Actions[0].Validate())
+	assert.Equal(t, `{"index_patterns":["foo*"],"template":{"settings":{"index.number_of_shards":2,"index.number_of_replicas":2},`+
+		`"mappings":{"properties":{"name":{"type":"keyword"},"query":{"type":"keyword","null_value":"{{query}}"}}}}}`, sc.Actions[0].JSON)
+}
+
+func TestYAMLScriptMultipleRequests(t *testing.T) {
+	sc, err := NewSchemaChange("../tests", "../tests/multiple_requests.yml", -1, -1)
+	require.NoError(t, err)
+	assert.Equal(t, RunOnChange, sc.RunMode)
+	require.Len(t, sc.Actions, 2)
+	assert.Equal(t, `{"processors":[{"set":{"field":"ingested","value":"{{_ingest.timestamp}}"}}]}`, sc.Actions[0].JSON)
+	assert.Equal(t, "GET", sc.Actions[1].HTTPVerb)
+	assert.Equal(t, []string{"$.cars"}, sc.Actions[1].Assert.Exists)
+	assert.Equal(t, ">=7.0.0", sc.Precondition.Version)
+}
+
+func TestYAMLScriptDiagnostics(t *testing.T) {
+	raw, err := readScript("../tests/index_template.yaml")
+	require.NoError(t, err)
+	diags := diagnose(raw)
+	require.Len(t, diags, 1)
+	assert.Equal(t, RuleUnknownToken, diags[0].Rule)
+	assert.Equal(t, 7, diags[0].Line)
+
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeScript(t, dir, "cars/01.yaml", "verb: PUT\nurl: cars\nbody:\n  settings: {\n  bad\n")
+	writeScript(t, dir, "cars/02.yaml", "owner: jane\nverb: put\nurl: cars\nbody:\n  a: 1\n")
+
+	results, err := NewRunner(dir, nil).Validate()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.False(t, results[0].IsValid)
+	assert.Equal(t, RuleBadYAML, results[0].Diagnostics[0].Rule)
+	assert.True(t, results[0].Diagnostics[0].Line > 0)
+
+	require.Len(t, results[1].Diagnostics, 2)
+	assert.Equal(t, Diagnostic{SeverityWarning, RuleUnknownHeader, 1, `unknown header "owner" is ignored`}, results[1].Diagnostics[0])
+	assert.Equal(t, RuleBadVerb, results[1].Diagnostics[1].Rule)
+	assert.Equal(t, 2, results[1].Diagnostics[1].Line)
+}
+
+func TestYAMLScriptBadStructure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = NewSchemaChange(dir, writeScript(t, dir, "cars/01.yaml", "requests:\n  verb: PUT\n"), -1, -1)
+	assert.True(t, errors.Is(err, ErrBadYAML))
+	assert.Equal(t, 2, err.(ErrScriptFile).Line)
+
+	_, err = NewSchemaChange(dir, writeScript(t, dir, "cars/02.yaml", "- PUT\n- cars\n"), -1, -1)
+	assert.True(t, errors.Is(err, ErrBadYAML))
+}
+
+func TestYAMLToJSONScalars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdeploy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sc, err := NewSchemaChange(dir, writeScript(t, dir, "cars/01.yaml",
+		"verb: PUT\nurl: cars\nbody:\n  a: 1.5\n  b: true\n  c: null\n  d: \"007\"\n  e: &x [1, 2]\n  f: *x\n"), -1, -1)
+	require.NoError(t, err)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(sc.Actions[0].JSON), &body))
+	assert.Equal(t, map[string]interface{}{
+		"a": 1.5, "b": true, "c": nil, "d": "007",
+		"e": []interface{}{float64(1), float64(2)},
+		"f": []interface{}{float64(1), float64(2)},
+	}, body)
+}
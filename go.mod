@@ -10,4 +10,5 @@ require (
 	github.com/mattn/go-colorable v0.1.7 // indirect
 	github.com/stretchr/testify v1.4.0
 	golang.org/x/sys v0.0.0-20200810151505-1b9f1253b3ed // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -23,10 +23,15 @@ var (
 	appUser     = app.Flag("username", "Username to authenticate with").Short('u').String()
 	appPassword = app.Flag("password", "Password to authenticat with").Short('p').String()
 	appInsecure = app.Flag("insecure", "Ignore SSL certificate warnings").Short('k').Bool()
+	appInclude  = app.Flag("include", "Only use files matching the glob pattern, can be repeated").Strings()
+	appExclude  = app.Flag("exclude", "Skip files and folders matching the glob pattern, can be repeated").Strings()
+	appHidden   = app.Flag("hidden", "Include hidden files and folders").Bool()
+	appMaxDepth = app.Flag("max-depth", "Folder levels to search for files, 1 is only the folder itself").Int()
 
-	drCmd  = app.Command("dryrun", "Only lists out changes that would be made to ElasticSearch.")
-	drURL  = drCmd.Arg("url", "Elastic Search URL to run against").Required().String()
-	drPath = drCmd.Flag("folder", "Folder containing schema js files").Short('f').Default(".").String()
+	drCmd     = app.Command("dryrun", "Only lists out changes that would be made to ElasticSearch.")
+	drURL     = drCmd.Arg("url", "Elastic Search URL to run against").Required().String()
+	drPath    = drCmd.Flag("folder", "Folder containing schema js files").Short('f').Default(".").String()
+	drProfile = drCmd.Flag("profile", "Deployment profile matched against script preconditions").String()
 
 	validateCmd  = app.Command("validate", "Performs a validation of all files to ensure they are properly formatted")
 	validatePath = validateCmd.Flag("folder", "Folder containing schema js files").Short('f').Default(".").String()
@@ -37,18 +42,60 @@ var (
 	dSilent   = deployCmd.Flag("silent", "Don't prompt for confirmation, run silently").Short('s').Bool()
 	dShards   = deployCmd.Flag("shards", "Default number of shards to use for new indexes if tokenized {{shards}}").Default("5").String()
 	dReplicas = deployCmd.Flag("replicas", "Default number of shard replicas if tokenized {{replicas}}").Default("1").String()
+	dProfile  = deployCmd.Flag("profile", "Deployment profile matched against script preconditions").String()
+	dGzip     = deployCmd.Flag("gzip", "Compress request bodies with gzip").Bool()
 
-	seedCmd  = app.Command("seed", "Seed elastic search with data stored in json files")
-	seedURL  = seedCmd.Arg("url", "Elastic Search URL to run against").Required().String()
-	seedPath = seedCmd.Flag("folder", "Folder containing json data files").Short('f').Default(".").String()
+	seedCmd       = app.Command("seed", "Seed elastic search with data stored in json files")
+	seedRunCmd    = seedCmd.Command("run", "Seed elastic search with data stored in json files").Default()
+	seedURL       = seedRunCmd.Arg("url", "Elastic Search URL to run against").Required().String()
+	seedReplayCmd = seedCmd.Command("replay", "List the batches in the poison folder, or seed them again when given a url")
+	replayURL     = seedReplayCmd.Arg("url", "Elastic Search URL to seed the batches again against").String()
+	replayBatches = seedReplayCmd.Arg("batch", "Names of the batches to seed again").Strings()
+	replayAll     = seedReplayCmd.Flag("all", "Seed every batch again").Bool()
+	seedPath      = seedCmd.Flag("folder", "Folder containing json data files").Short('f').Default(".").String()
+	seedGzip      = seedCmd.Flag("gzip", "Compress request bodies with gzip").Bool()
+	seedKeep      = seedCmd.Flag("keep", "Leave data files in place (default in a git repository)").Bool()
+	seedRemove    = seedCmd.Flag("remove", "Delete seeded files and move failures to the poison folder, even in a git repository").Bool()
+	seedReport    = seedCmd.Flag("report", "File to write the outcome of each data file to as JSON").String()
+	seedForce     = seedCmd.Flag("force", "Seed every data file again, even when unchanged since it was last seeded").Bool()
+	seedBatch     = seedCmd.Flag("batch-size", "Documents sent in each _bulk request, 1 sends a request per file").Default("500").Int()
+	seedBytes     = seedCmd.Flag("batch-bytes", "Maximum size of a _bulk request in bytes, larger documents are sent on their own").Default("5242880").Int()
+	seedWorkers   = seedCmd.Flag("workers", "Number of requests sent at the same time").Default("1").Int()
+	seedRPS       = seedCmd.Flag("requests-per-second", "Maximum requests sent each second, 0 for no limit").Default("0").Float64()
+	seedBPS       = seedCmd.Flag("bytes-per-second", "Maximum bytes sent each second, 0 for no limit").Default("0").Int64()
+	seedTimeout   = seedCmd.Flag("timeout", "How long each request may take before it fails, 0 for no limit").Default("0").Duration()
+	seedRetries   = seedCmd.Flag("retries", "Attempts at a request that is rejected (429, 503) or can not connect").Default("5").Int()
+	seedVars      = seedCmd.Flag("var", "Value of a {{token}} in the data files as name=value, can be repeated").StringMap()
+
+	exportCmd     = app.Command("export", "Export the documents of an index to seed files")
+	exportURL     = exportCmd.Arg("url", "Elastic Search URL to export from").Required().String()
+	exportIndex   = exportCmd.Arg("index", "Index or alias to export").Required().String()
+	exportPath    = exportCmd.Flag("folder", "Folder to write the seed files to").Short('f').Default(".").String()
+	exportQuery   = exportCmd.Flag("query", "Query clause (JSON) selecting the documents to export").String()
+	exportFormat  = exportCmd.Flag("format", "js for a seed file per document, ndjson for a single data file").Default(elastic.ExportSeedFiles).Enum(elastic.ExportSeedFiles, elastic.ExportNDJSON)
+	exportSize    = exportCmd.Flag("size", "Documents read in each request").Default("1000").Int()
+	exportPIT     = exportCmd.Flag("pit", "Page with a point in time and search_after instead of a scroll (elastic search 7.12+)").Bool()
+	exportIDField = exportCmd.Flag("id-field", "Field holding the ID of each document, otherwise the ndjson file keeps the ID as _id").String()
+
+	migrateCmd  = app.Command("migrate", "Rewrites the esdeploy_v1 records of applied scripts to IDs based on their path")
+	migrateURL  = migrateCmd.Arg("url", "Elastic Search URL to run against").Required().String()
+	migratePath = migrateCmd.Flag("folder", "Folder containing schema js files").Short('f').Default(".").String()
+
+	renameCmd  = app.Command("rename", "Moves the esdeploy_v1 records of renamed scripts from their previous IDs")
+	renameURL  = renameCmd.Arg("url", "Elastic Search URL to run against").Required().String()
+	renamePath = renameCmd.Flag("folder", "Folder containing schema js files").Short('f').Default(".").String()
 
 	versionCmd = app.Command("version", "Display version of esdeploy")
 )
 
 func main() {
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 	var cred elastic.Creds
+	if *appUser != "" && *appPassword != "" {
+		cred = elastic.Creds{Username: *appUser, Password: *appPassword}
+	}
 
-	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
+	switch command {
 
 	case versionCmd.FullCommand():
 		color.Cyan("version %v", version)
@@ -62,14 +109,25 @@ func main() {
 		exit := 0
 		color.Cyan("Running validation against folder %v", *validatePath)
 		esRunner := elastic.NewRunner(*validatePath, nil)
-		results := esRunner.Validate()
+		esRunner.Filter = fileFilter()
+		results, err := esRunner.Validate()
+		if err != nil {
+			log.Fatal(err)
+		}
 		for _, r := range results {
 			if !r.IsValid {
 				color.Red("FILE INVALID: %s", r.File)
 				exit = 1
-				continue
+			} else {
+				color.Green("File Valid: %s", r.File)
+			}
+			for _, d := range r.Diagnostics {
+				if d.Severity == elastic.SeverityError {
+					color.Red("    %v", d)
+				} else {
+					color.Yellow("    %v", d)
+				}
 			}
-			color.Green("File Valid: %s", r.File)
 		}
 
 		color.Cyan("Validation completed")
@@ -84,8 +142,13 @@ func main() {
 		color.Cyan("Running dry run against %v", *drURL)
 		color.Cyan("Folder containing schema files is %v", *drPath)
 
-		schemaChanger := elastic.NewEsSchemaChanger(*drURL, cred, *appInsecure)
+		schemaChanger, err := elastic.NewEsSchemaChanger(*drURL, cred, *appInsecure)
+		if err != nil {
+			log.Fatal(err)
+		}
 		esRunner := elastic.NewRunner(*drPath, schemaChanger)
+		esRunner.Filter = fileFilter()
+		esRunner.Profile = *drProfile
 		results, err := esRunner.DryRun()
 		if err != nil {
 			log.Fatal(err)
@@ -101,10 +164,6 @@ func main() {
 			*dPath, _ = os.Getwd()
 		}
 
-		if *appUser != "" && *appPassword != "" {
-			cred = elastic.Creds{Username: *appUser, Password: *appPassword}
-		}
-
 		color.Cyan("About to perform deployment against %v", *dURL)
 		color.Cyan("Folder containing schema files is %v", *dPath)
 
@@ -117,8 +176,14 @@ func main() {
 			}
 		}
 
-		schemaChanger := elastic.NewEsSchemaChanger(*dURL, cred, *appInsecure)
+		schemaChanger, err := elastic.NewEsSchemaChanger(*dURL, cred, *appInsecure)
+		if err != nil {
+			log.Fatal(err)
+		}
+		schemaChanger.Compress = *dGzip
 		esRunner := elastic.NewRunner(*dPath, schemaChanger)
+		esRunner.Filter = fileFilter()
+		esRunner.Profile = *dProfile
 		shards, err := strconv.Atoi(*dShards)
 		if err != nil {
 			shards = -1
@@ -140,8 +205,89 @@ func main() {
 			color.Green("%v", r)
 		}
 		color.Cyan("Deploy completed")
+	//Migrate IDs of applied scripts
+	case migrateCmd.FullCommand():
+		if *migratePath == "" {
+			*migratePath, _ = os.Getwd()
+		}
+
+		color.Cyan("Migrating script IDs against %v", *migrateURL)
+		color.Cyan("Folder containing schema files is %v", *migratePath)
+
+		schemaChanger, err := elastic.NewEsSchemaChanger(*migrateURL, cred, *appInsecure)
+		if err != nil {
+			log.Fatal(err)
+		}
+		esRunner := elastic.NewRunner(*migratePath, schemaChanger)
+		esRunner.Filter = fileFilter()
+		results, err := esRunner.MigrateIDs()
+		if err != nil {
+			for _, r := range results {
+				color.Red("%v", r)
+			}
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		for _, r := range results {
+			color.Green("%v", r)
+		}
+		color.Cyan("Migration completed")
+	//Rename IDs of moved scripts
+	case renameCmd.FullCommand():
+		if *renamePath == "" {
+			*renamePath, _ = os.Getwd()
+		}
+
+		color.Cyan("Renaming script IDs against %v", *renameURL)
+		color.Cyan("Folder containing schema files is %v", *renamePath)
+
+		schemaChanger, err := elastic.NewEsSchemaChanger(*renameURL, cred, *appInsecure)
+		if err != nil {
+			log.Fatal(err)
+		}
+		esRunner := elastic.NewRunner(*renamePath, schemaChanger)
+		esRunner.Filter = fileFilter()
+		results, err := esRunner.RenameIDs()
+		if err != nil {
+			for _, r := range results {
+				color.Red("%v", r)
+			}
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		for _, r := range results {
+			color.Green("%v", r)
+		}
+		color.Cyan("Rename completed")
+	//Export documents to seed files
+	case exportCmd.FullCommand():
+		if *exportPath == "" {
+			*exportPath, _ = os.Getwd()
+		}
+
+		color.Cyan("Exporting %v from %v", *exportIndex, *exportURL)
+		color.Cyan("Folder for the seed files is %v", *exportPath)
+
+		exporter := elastic.NewExporter(*exportURL, *exportIndex, *exportPath, cred, *appInsecure)
+		exporter.Query = *exportQuery
+		exporter.Format = *exportFormat
+		exporter.PageSize = *exportSize
+		exporter.PIT = *exportPIT
+		exporter.IDField = *exportIDField
+		results, err := exporter.Export()
+		if err != nil {
+			for _, r := range results {
+				color.Red("%v", r)
+			}
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		for _, r := range results {
+			color.Green("%v", r)
+		}
+		color.Cyan("Export completed")
 	//Seed data
-	case seedCmd.FullCommand():
+	case seedRunCmd.FullCommand():
 		if *seedPath == "" {
 			*seedPath, _ = os.Getwd()
 		}
@@ -149,7 +295,12 @@ func main() {
 		color.Cyan("Seeding data against %v", *seedURL)
 		color.Cyan("Folder containing data files is %v", *seedPath)
 
-		seeder := elastic.NewSeeder(*seedPath, *seedURL, cred)
+		seeder := newSeeder(*seedURL, cred)
+		if *seedKeep {
+			seeder.Keep = true
+		} else if *seedRemove {
+			seeder.Keep = false
+		}
 		results, err := seeder.Seed()
 		if err != nil {
 			log.Fatal(err)
@@ -158,5 +309,76 @@ func main() {
 			color.Green("%v", r)
 		}
 		color.Cyan("Seeding completed")
+	//List or replay poison batches
+	case seedReplayCmd.FullCommand():
+		if *seedPath == "" {
+			*seedPath, _ = os.Getwd()
+		}
+
+		seeder := newSeeder(*replayURL, cred)
+		if *replayURL == "" {
+			color.Cyan("Poison batches in %v", *seedPath)
+			batches, err := seeder.PoisonBatches()
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, b := range batches {
+				color.Cyan("%s: %d file(s)", b.Name, len(b.Files))
+				for _, f := range b.Files {
+					if f.Path != f.File {
+						color.Red("    %s (from %s): %s", f.File, f.Path, f.Error)
+					} else {
+						color.Red("    %s: %s", f.File, f.Error)
+					}
+				}
+			}
+			os.Exit(0)
+		}
+		if len(*replayBatches) == 0 && !*replayAll {
+			color.Red("Name the batches to seed again or use --all")
+			os.Exit(1)
+		}
+
+		color.Cyan("Seeding poison batches again against %v", *replayURL)
+		results, err := seeder.Replay(*replayBatches)
+		if err != nil {
+			for _, r := range results {
+				color.Red("%v", r)
+			}
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		for _, r := range results {
+			color.Green("%v", r)
+		}
+		color.Cyan("Replay completed")
+	}
+}
+
+// newSeeder builds the seeder for the folder from the seed flags
+func newSeeder(url string, cred elastic.Creds) *elastic.Seeder {
+	seeder := elastic.NewSeeder(*seedPath, url, cred, *appInsecure)
+	seeder.Compress = *seedGzip
+	seeder.ReportFile = *seedReport
+	seeder.Force = *seedForce
+	seeder.BatchSize = *seedBatch
+	seeder.BatchBytes = *seedBytes
+	seeder.Workers = *seedWorkers
+	seeder.RequestsPerSecond = *seedRPS
+	seeder.BytesPerSecond = *seedBPS
+	seeder.Timeout = *seedTimeout
+	seeder.Retries = *seedRetries
+	seeder.Vars = *seedVars
+	seeder.Filter = fileFilter()
+	return seeder
+}
+
+// fileFilter builds the filter for the files in the folder from the flags
+func fileFilter() elastic.FileFilter {
+	return elastic.FileFilter{
+		Include:  *appInclude,
+		Exclude:  *appExclude,
+		Hidden:   *appHidden,
+		MaxDepth: *appMaxDepth,
 	}
 }
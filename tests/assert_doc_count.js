@@ -0,0 +1,7 @@
+GET
+foo_index/_count
+ASSERT
+{
+  "status": 200,
+  "equals": { "$.count": 42 }
+}
@@ -0,0 +1,8 @@
+// description: Seed the car makes lookup index
+POST
+makes/_bulk?refresh=true
+{ "index": { "_id": "1" } }
+{ "name": "Ford" }
+
+{ "index": { "_id": "2" } }
+{ "name": "Toyota" }
\ No newline at end of file
@@ -0,0 +1,12 @@
+// description: Stored script for scoring cars
+POST
+_scripts/cars-score
+{
+  // stored scripts keep their layout
+  "script": {
+    "lang": "painless",
+    /* newer cars score higher,
+       the year field is required */
+    "source": "return doc['year'].value / 2000.0; // not a comment"
+  }
+}
\ No newline at end of file
@@ -0,0 +1,20 @@
+// description: Create the cars index with an alias and pipeline
+PUT
+cars_v1
+{
+  "settings": { "index.number_of_shards": {{shards}} }
+}
+
+POST /_aliases
+{
+  "actions": [ { "add": { "index": "cars_v1", "alias": "cars" } } ]
+}
+ASSERT
+{ "equals": { "$.acknowledged": true } }
+
+PUT _ingest/pipeline/cars?retry=2
+{
+  "processors": []
+}
+WHEN
+{ "indexMissing": "cars_v0" }
\ No newline at end of file